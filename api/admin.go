@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/utils"
+)
+
+// Allow the request only with the admin bearer token, admin endpoints are disabled without ADMIN_TOKEN
+func (state *ApiState) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if state.adminToken == "" {
+			http.Error(w, "admin api is disabled", http.StatusForbidden)
+			return
+		}
+
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(state.adminToken)) != 1 {
+			utils.Logger(r.Context()).Warn("Rejected admin request", "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+const (
+	adminTransactionsLimit    = 50
+	adminTransactionsMaxLimit = 500
+)
+
+func nullableString(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+// Ledger entry as shown to support staff
+type adminTransaction struct {
+	Token          string    `json:"token"`
+	GatewayID      *string   `json:"gateway_id"`
+	OperationType  string    `json:"operation_type"`
+	Amount         int64     `json:"amount"`
+	Currency       *string   `json:"currency"`
+	ProviderAmount *float64  `json:"provider_amount"`
+	ProviderStatus *string   `json:"provider_status"`
+	RpStatus       string    `json:"rp_status"`
+	Sandbox        bool      `json:"sandbox"`
+	ExternalID     string    `json:"external_id"`
+	RedirectUrl    *string   `json:"redirect_url"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func newAdminTransaction(transaction db.Transaction) adminTransaction {
+	var providerAmount *float64
+	if transaction.ProviderAmount.Valid {
+		providerAmount = &transaction.ProviderAmount.Float64
+	}
+
+	return adminTransaction{
+		Token:          transaction.Token,
+		GatewayID:      nullableString(transaction.GatewayID),
+		OperationType:  transaction.OperationType,
+		Amount:         transaction.Amount,
+		Currency:       nullableString(transaction.Currency),
+		ProviderAmount: providerAmount,
+		ProviderStatus: nullableString(transaction.ProviderStatus),
+		RpStatus:       transaction.RpStatus,
+		Sandbox:        transaction.Sandbox,
+		ExternalID:     transaction.ExternalID,
+		RedirectUrl:    nullableString(transaction.RedirectUrl),
+		CreatedAt:      transaction.CreatedAt,
+		UpdatedAt:      transaction.UpdatedAt,
+	}
+}
+
+// Business callback delivery state
+type adminCallback struct {
+	ID            int64     `json:"id"`
+	Payload       string    `json:"payload"`
+	Status        string    `json:"status"`
+	Attempts      int64     `json:"attempts"`
+	LastError     *string   `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func newAdminCallback(callback db.CallbackOutbox) adminCallback {
+	return adminCallback{
+		ID:            callback.ID,
+		Payload:       callback.Payload,
+		Status:        callback.Status,
+		Attempts:      callback.Attempts,
+		LastError:     nullableString(callback.LastError),
+		NextAttemptAt: callback.NextAttemptAt,
+		CreatedAt:     callback.CreatedAt,
+		UpdatedAt:     callback.UpdatedAt,
+	}
+}
+
+// Gateway id mapping with everything known about the operation, merchant private key is never exposed
+type adminMapping struct {
+	GatewayID       string                 `json:"gateway_id"`
+	Token           string                 `json:"token"`
+	Transaction     *adminTransaction      `json:"transaction"`
+	InteractionLogs []storedInteractionLog `json:"interaction_logs"`
+	Callbacks       []adminCallback        `json:"callbacks"`
+}
+
+func (state *ApiState) loadAdminMapping(ctx context.Context, mapping db.SearchMappingsRow) (adminMapping, error) {
+	result := adminMapping{
+		GatewayID: mapping.GatewayID,
+		Token:     mapping.Token,
+		Callbacks: []adminCallback{},
+	}
+
+	transaction, err := state.queries.GetTransactionByToken(ctx, mapping.Token)
+	if err == nil {
+		stored := newAdminTransaction(transaction)
+		result.Transaction = &stored
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return result, fmt.Errorf("failed to load transaction: %w", err)
+	}
+
+	result.InteractionLogs, err = state.listInteractionLogs(ctx, mapping.Token, mapping.GatewayID)
+	if err != nil {
+		return result, fmt.Errorf("failed to list interaction logs: %w", err)
+	}
+
+	callbacks, err := state.queries.ListCallbacksByGatewayID(ctx, mapping.GatewayID)
+	if err != nil {
+		return result, fmt.Errorf("failed to list callbacks: %w", err)
+	}
+	for _, callback := range callbacks {
+		result.Callbacks = append(result.Callbacks, newAdminCallback(callback))
+	}
+
+	return result, nil
+}
+
+// Find which connect token a provider id belongs to and the other way around
+func (state *ApiState) AdminMappingsHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	gatewayID := r.URL.Query().Get("gateway_id")
+	if token == "" && gatewayID == "" {
+		http.Error(w, "token or gateway_id is required", http.StatusBadRequest)
+		return
+	}
+
+	logger := utils.Logger(r.Context())
+	mappings, err := state.queries.SearchMappings(r.Context(), db.SearchMappingsParams{
+		Token:     token,
+		GatewayID: gatewayID,
+	})
+	if err != nil {
+		logger.Error("Failed to search mappings", "err", err)
+		http.Error(w, "failed to search mappings", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]adminMapping, 0, len(mappings))
+	for _, mapping := range mappings {
+		result, err := state.loadAdminMapping(r.Context(), mapping)
+		if err != nil {
+			logger.Error("Failed to load mapping details", "gateway_id", mapping.GatewayID, "err", err)
+			http.Error(w, "failed to load mapping details", http.StatusInternalServerError)
+			return
+		}
+		response = append(response, result)
+	}
+
+	utils.WriteJSON(w, response)
+}
+
+// Unbounded creation date range of the transaction listing
+var (
+	adminTimeMin = time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	adminTimeMax = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+)
+
+// Accepts RFC 3339 timestamps and plain dates, fallback is used for an empty value
+func parseAdminTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+	parsed, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 timestamp or date, got %q", value)
+	}
+	return parsed, nil
+}
+
+func parseTransactionFilters(query url.Values) (db.ListTransactionsParams, error) {
+	params := db.ListTransactionsParams{
+		RpStatus:      nullString(query.Get("status")),
+		OperationType: nullString(query.Get("operation_type")),
+		Limit:         adminTransactionsLimit,
+	}
+
+	var err error
+	if params.CreatedAfter, err = parseAdminTime(query.Get("from"), adminTimeMin); err != nil {
+		return params, fmt.Errorf("invalid from: %w", err)
+	}
+	if params.CreatedBefore, err = parseAdminTime(query.Get("to"), adminTimeMax); err != nil {
+		return params, fmt.Errorf("invalid to: %w", err)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 {
+			return params, fmt.Errorf("invalid limit: %q", limit)
+		}
+		params.Limit = int64(min(parsed, adminTransactionsMaxLimit))
+	}
+
+	return params, nil
+}
+
+// Recent transactions, newest first, filtered by status, operation type and creation date
+func (state *ApiState) AdminTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseTransactionFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transactions, err := state.queries.ListTransactions(r.Context(), params)
+	if err != nil {
+		utils.Logger(r.Context()).Error("Failed to list transactions", "err", err)
+		http.Error(w, "failed to list transactions", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]adminTransaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		response = append(response, newAdminTransaction(transaction))
+	}
+	utils.WriteJSON(w, response)
+}
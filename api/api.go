@@ -2,49 +2,101 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
+	"github.com/dog4ik/stbl/config"
 	"github.com/dog4ik/stbl/connect"
 	"github.com/dog4ik/stbl/db"
 	"github.com/dog4ik/stbl/gateway"
+	"github.com/dog4ik/stbl/metrics"
 	"github.com/dog4ik/stbl/utils"
 )
 
 type ApiState struct {
-	client            *http.Client
-	queries           *db.Queries
-	businessUrl       string
-	signKey           string
-	sandboxGatewayUrl string
-	prodGatewayUrl    string
-	callbackUrl       string
+	client        *http.Client
+	conn          *sql.DB
+	queries       *db.Store
+	gatewayConfig *gateway.ClientConfig
+	businessUrl   string
+	signKey       string
+	outbox        outboxConfig
+	outboxWake    chan struct{}
+	poller        pollerConfig
+	readiness     readinessConfig
+	adminToken    string
+
+	interactionLogs interactionLogConfig
+
+	callbackVerification callbackVerification
+
+	// Serializes pay and payout requests with the same connect token
+	tokenLocks tokenLocks
 }
 
-func NewState(queries *db.Queries) *ApiState {
-	businessUrl := utils.ExpectEnv("BUSINESS_URL")
-	signKey := utils.ExpectEnv("SIGN_KEY")
-	sandboxGatewayUrl := utils.ExpectEnv("SANDBOX_BASE_URL")
-	prodGatewayUrl := utils.ExpectEnv("BASE_URL")
+func NewState(conn *sql.DB, queries *db.Store, cfg *config.Config) *ApiState {
 	client := &http.Client{Timeout: 30 * time.Second}
+	outbox := outboxConfig{
+		maxAttempts:      cfg.Callbacks.MaxAttempts,
+		retryInterval:    cfg.Callbacks.RetryInterval,
+		maxRetryInterval: cfg.Callbacks.MaxRetryInterval,
+	}
+	tokens := gateway.NewTokenStore(queries, cfg.Gateway.AccessTokenTTL, cfg.Gateway.RefreshTokenTTL)
+	poller := pollerConfig{
+		interval:    cfg.Poller.Interval,
+		maxAge:      cfg.Poller.MaxAge,
+		concurrency: cfg.Poller.Concurrency,
+	}
+	gatewayConfig := &gateway.ClientConfig{
+		Client:         client,
+		Tokens:         tokens,
+		Breakers:       gateway.NewBreakers(cfg.Gateway.Breaker, cfg.Gateway.ProdBaseUrl, cfg.Gateway.SandboxBaseUrl),
+		Limiters:       gateway.NewLimiters(cfg.Gateway.RateLimits),
+		Timeouts:       cfg.Gateway.Timeouts,
+		Retry:          cfg.Gateway.Retry,
+		ProdBaseUrl:    cfg.Gateway.ProdBaseUrl,
+		SandboxBaseUrl: cfg.Gateway.SandboxBaseUrl,
+	}
+	interactionLogs := interactionLogConfig{
+		retention:     cfg.InteractionLogs.Retention,
+		purgeInterval: cfg.InteractionLogs.PurgeInterval,
+	}
+	readiness := readinessConfig{
+		probeProvider: cfg.Readiness.ProbeProvider,
+		timeout:       cfg.Readiness.Timeout,
+	}
 
 	return &ApiState{
-		client:            client,
-		queries:           queries,
-		businessUrl:       businessUrl,
-		signKey:           signKey,
-		sandboxGatewayUrl: sandboxGatewayUrl,
-		prodGatewayUrl:    prodGatewayUrl,
+		client:        client,
+		conn:          conn,
+		queries:       queries,
+		gatewayConfig: gatewayConfig,
+		businessUrl:   cfg.BusinessUrl,
+		signKey:       string(cfg.SignKey),
+		outbox:        outbox,
+		outboxWake:    make(chan struct{}, 1),
+		poller:        poller,
+		readiness:     readiness,
+		adminToken:    string(cfg.AdminToken),
+
+		interactionLogs:      interactionLogs,
+		callbackVerification: newCallbackVerification(cfg.Callbacks),
+		tokenLocks:           newTokenLocks(),
 	}
 }
 
-func (state *ApiState) newGatewayClient(ctx context.Context, settings connect.Settings) (gateway.GatewayClient, connect.InteractionLogs, error) {
-	return gateway.NewGatewayClient(ctx, settings, state.client, state.queries, state.prodGatewayUrl, state.sandboxGatewayUrl, state.callbackUrl)
+func (state *ApiState) newGatewayClient(ctx context.Context, settings connect.Settings, il *connect.InteractionLogs) (*gateway.GatewayClient, error) {
+	return gateway.NewGatewayClient(ctx, settings, il, state.gatewayConfig)
+}
+
+// State of provider circuit breakers
+func (state *ApiState) CircuitsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, state.gatewayConfig.Breakers.Status())
 }
 
+// Remember which connect payment and credentials the gateway id belongs to
 func writePayoutPendingResponse(w http.ResponseWriter, interactionLogs connect.InteractionLogs, redirect connect.RedirectRequest) {
 	utils.WriteJSON(
 		w,
@@ -69,328 +121,416 @@ func writeErrorResponse(w http.ResponseWriter, interactionLogs connect.Interacti
 	)
 }
 
-func gatewayErrorMessage(body []byte) string {
-	var ge gateway.GatewayError
-	if err := json.Unmarshal(body, &ge); err == nil && ge.Detail != nil {
-		return *ge.Detail
+// Error response for a failed provider call, carries the kind of the failure
+func writeGatewayErrorResponse(w http.ResponseWriter, interactionLogs connect.InteractionLogs, err error) {
+	var kind string
+	if gatewayErr := gateway.AsError(err); gatewayErr != nil {
+		kind = string(gatewayErr.Kind)
+	}
+
+	utils.WriteJSON(
+		w,
+		connect.GwConnectError{
+			Result: false,
+			Logs:   interactionLogs.IntoInner(),
+			Error:  err.Error(),
+			Kind:   kind,
+		},
+	)
+}
+
+// Answer failed payment or payout creation.
+// Operation stays pending if the provider might have created it, otherwise it is declined.
+func (state *ApiState) writeCreateFailure(
+	w http.ResponseWriter,
+	r *http.Request,
+	interactionLogs connect.InteractionLogs,
+	token string,
+	redirectUrl string,
+	err error,
+) {
+	logger := utils.Logger(r.Context())
+	state.saveInteractionLogs(r.Context(), &interactionLogs, token, nil)
+
+	if gatewayErr := gateway.AsError(err); gatewayErr != nil && gatewayErr.MightHaveSucceeded() {
+		logger.Warn("Provider might have created the operation, leaving it pending", "err", err)
+		writePayoutPendingResponse(w, interactionLogs, connect.NewGetRedirect(redirectUrl))
+		return
 	}
-	return "bad gateway response"
+
+	logger.Error("Provider did not create the operation", "err", err)
+	state.recordDecline(r.Context(), token)
+	writeGatewayErrorResponse(w, interactionLogs, err)
 }
 
 func (state *ApiState) PaymentHandler(w http.ResponseWriter, r *http.Request) {
 	payment, err := utils.DecodeJSONRequest[connect.PayoutRequest](r.Body, w)
 	if err != nil {
-		log.Printf("Failed to decode gateway connect request: %s", err)
+		utils.Logger(r.Context()).Warn("Failed to decode gateway connect request", "err", err)
 		writeErrorResponse(w, connect.EmptyInteractionLogs(), err.Error())
 		return
 	}
 
-	client, il, err := state.newGatewayClient(r.Context(), payment.Settings)
+	r = r.WithContext(utils.WithLogAttrs(r.Context(), "operation", connect.OperationPay, "token", payment.Payment.Token))
+	logger := utils.Logger(r.Context())
+
+	unlock := state.tokenLocks.lock(payment.Payment.Token)
+	defer unlock()
+
+	existing, err := state.recordTransaction(r.Context(), connect.OperationPay, payment)
 	if err != nil {
-		log.Printf("Failed to initiate gateway client: %v", err)
-		writeErrorResponse(w, il, err.Error())
+		// Without the ledger entry the token is not claimed, so the provider is not called
+		logger.Error("Failed to record transaction", "err", err)
+		writeErrorResponse(w, connect.EmptyInteractionLogs(), "failed to record transaction")
+		return
+	}
+	if existing != nil {
+		logger.Info("Token was already submitted, replaying stored response")
+		writeStoredSubmission(w, *existing, connect.OperationPay, payment.ProcessingUrl)
 		return
 	}
 
-	span := il.Enter("payment")
-	res, err := client.Payment(payment, span)
+	il := connect.NewInteractionLogs(utils.RequestID(r.Context()))
+	client, err := state.newGatewayClient(r.Context(), payment.Settings, &il)
 	if err != nil {
-		log.Printf("ERROR: Failed to create payout: %s", err)
-		writeErrorResponse(w, il, fmt.Sprintf("Gateway request failed: %s", err))
+		state.writeCreateFailure(w, r, il, payment.Payment.Token, payment.ProcessingUrl, err)
 		return
 	}
-	defer res.Body.Close()
 
-	body := utils.DecodeBody(res.Body, span)
-	if res.StatusCode == http.StatusCreated {
-		gatewayPayment, err := utils.UnmarshalBytes[gateway.PaymentResponse](body)
-		// json deserialization error
-		if err != nil {
-			writeErrorResponse(w, il, fmt.Sprintf("Failed to deserilaize gateway response: %s", err))
-			return
-		}
-
-		// required fields are missing
-		if gatewayPayment.ID == nil {
-			writeErrorResponse(w, il, fmt.Sprintf("Payment response missing required fields: %s", err))
-			return
-		}
+	span := il.Enter("payment")
+	gatewayPayment, err := client.Payment(r.Context(), payment, span)
+	if err != nil {
+		state.writeCreateFailure(w, r, il, payment.Payment.Token, payment.ProcessingUrl, err)
+		return
+	}
 
-		if _, err = state.queries.CreateMapping(
-			r.Context(),
-			db.CreateMappingParams{Token: payment.Payment.Token, MerchantPrivateKey: payment.Payment.MerchantPrivateKey, GatewayID: *gatewayPayment.ID},
-		); err != nil {
-			log.Printf("ERROR: Failed to insert gateway token mapping: %s", err)
-		}
+	state.recordSubmission(r.Context(), payment, submission{
+		gatewayID:      *gatewayPayment.ID,
+		providerAmount: gatewayPayment.Amount,
+		providerStatus: string(gatewayPayment.Status.Name),
+		rpStatus:       gatewayPayment.Status.Name.ToRPStatus(),
+		redirectUrl:    gatewayPayment.PayFormLink,
+	})
+	state.saveInteractionLogs(r.Context(), &il, payment.Payment.Token, gatewayPayment.ID)
 
-		utils.WriteJSON(
-			w,
-			connect.PayoutResponse{
-				Result:          true,
-				Logs:            il.IntoInner(),
-				RedirectRequest: connect.NewGetRedirect(gatewayPayment.PayFormLink),
-				Status:          gatewayPayment.Status.Name.ToRPStatus(),
-				GatewayToken:    gatewayPayment.ID,
-			},
-		)
-	} else {
-		utils.WriteJSON(
-			w,
-			connect.GwConnectError{
-				Result: false,
-				Error:  gatewayErrorMessage(body),
-				Logs:   il.IntoInner(),
-			},
-		)
-	}
+	utils.WriteJSON(
+		w,
+		connect.PayoutResponse{
+			Result:          true,
+			Logs:            il.IntoInner(),
+			RedirectRequest: connect.NewGetRedirect(gatewayPayment.PayFormLink),
+			Status:          gatewayPayment.Status.Name.ToRPStatus(),
+			GatewayToken:    gatewayPayment.ID,
+		},
+	)
 }
+
 func (state *ApiState) PayoutHandler(w http.ResponseWriter, r *http.Request) {
 	payout, err := utils.DecodeJSONRequest[connect.PayoutRequest](r.Body, w)
 	if err != nil {
-		log.Printf("Failed to decode gateway connect request: %s", err)
+		utils.Logger(r.Context()).Warn("Failed to decode gateway connect request", "err", err)
 		writeErrorResponse(w, connect.EmptyInteractionLogs(), err.Error())
 		return
 	}
 
-	client, il, err := state.newGatewayClient(r.Context(), payout.Settings)
+	r = r.WithContext(utils.WithLogAttrs(r.Context(), "operation", connect.OperationPayout, "token", payout.Payment.Token))
+	logger := utils.Logger(r.Context())
+
+	unlock := state.tokenLocks.lock(payout.Payment.Token)
+	defer unlock()
+
+	existing, err := state.recordTransaction(r.Context(), connect.OperationPayout, payout)
 	if err != nil {
-		log.Printf("Failed to initiate gateway client: %v", err)
-		writeErrorResponse(w, il, err.Error())
+		// Without the ledger entry the token is not claimed, so the provider is not called
+		logger.Error("Failed to record transaction", "err", err)
+		writeErrorResponse(w, connect.EmptyInteractionLogs(), "failed to record transaction")
+		return
+	}
+	if existing != nil {
+		logger.Info("Token was already submitted, replaying stored response")
+		writeStoredSubmission(w, *existing, connect.OperationPayout, payout.ProcessingUrl)
 		return
 	}
 
-	span := il.Enter("payout")
-
-	res, err := client.Payout(payout, span)
+	il := connect.NewInteractionLogs(utils.RequestID(r.Context()))
+	client, err := state.newGatewayClient(r.Context(), payout.Settings, &il)
 	if err != nil {
-		log.Printf("ERROR: Failed to create payout: %s", err)
-		writeErrorResponse(w, il, fmt.Sprintf("Gateway request failed: %s", err))
+		state.writeCreateFailure(w, r, il, payout.Payment.Token, payout.ProcessingUrl, err)
 		return
 	}
-	defer res.Body.Close()
 
-	body := utils.DecodeBody(res.Body, span)
-	if res.StatusCode == http.StatusCreated {
-		providerPayout, err := utils.UnmarshalBytes[gateway.PayoutResponse](body)
-		// json deserialization error
-		if err != nil {
-			writePayoutPendingResponse(w, il, connect.NewGetRedirect(payout.ProcessingUrl))
-			return
-		}
-
-		// required fields are missing
-		if providerPayout.ID == nil {
-			writePayoutPendingResponse(w, il, connect.NewGetRedirect(payout.ProcessingUrl))
-			return
-		}
-
-		if _, err = state.queries.CreateMapping(
-			r.Context(),
-			db.CreateMappingParams{Token: payout.Payment.Token, MerchantPrivateKey: payout.Payment.MerchantPrivateKey, GatewayID: *providerPayout.ID},
-		); err != nil {
-			log.Printf("ERROR: Failed to insert gateway token mapping: %s", err)
-		}
+	span := il.Enter("payout")
+	providerPayout, err := client.Payout(r.Context(), payout, span)
+	if err != nil {
+		state.writeCreateFailure(w, r, il, payout.Payment.Token, payout.ProcessingUrl, err)
+		return
+	}
 
-		utils.WriteJSON(
-			w,
-			connect.PayoutResponse{
-				Result:          true,
-				Logs:            il.IntoInner(),
-				RedirectRequest: connect.NewGetRedirect(payout.ProcessingUrl),
-				Status:          providerPayout.Status.Name.ToRPStatus(),
-				GatewayToken:    providerPayout.ID,
-			},
-		)
-	} else if res.StatusCode >= 500 {
-		writePayoutPendingResponse(w, il, connect.NewGetRedirect(payout.ProcessingUrl))
-	} else {
-		var message string
-		gatewayError, _ := utils.UnmarshalBytes[gateway.GatewayError](body)
-		if gatewayError.Detail != nil {
-			message = *gatewayError.Detail
-		} else {
-			writePayoutPendingResponse(w, il, connect.NewGetRedirect(payout.ProcessingUrl))
-			return
-		}
+	state.recordSubmission(r.Context(), payout, submission{
+		gatewayID:      *providerPayout.ID,
+		providerAmount: providerPayout.Amount,
+		providerStatus: string(providerPayout.Status.Name),
+		rpStatus:       providerPayout.Status.Name.ToRPStatus(),
+		redirectUrl:    payout.ProcessingUrl,
+	})
+	state.saveInteractionLogs(r.Context(), &il, payout.Payment.Token, providerPayout.ID)
 
-		utils.WriteJSON(
-			w,
-			connect.GwConnectError{
-				Result: false,
-				Error:  message,
-				Logs:   il.IntoInner(),
-			},
-		)
-	}
+	utils.WriteJSON(
+		w,
+		connect.PayoutResponse{
+			Result:          true,
+			Logs:            il.IntoInner(),
+			RedirectRequest: connect.NewGetRedirect(payout.ProcessingUrl),
+			Status:          providerPayout.Status.Name.ToRPStatus(),
+			GatewayToken:    providerPayout.ID,
+		},
+	)
 }
 
 func (state *ApiState) StatusHandler(w http.ResponseWriter, r *http.Request) {
 	status, err := utils.DecodeJSONRequest[connect.StatusRequest](r.Body, w)
-	log.Printf("Status request: %v", utils.ToJSON(status))
 	if err != nil {
-		log.Printf("Failed to decode gateway connect request: %s", err)
+		utils.Logger(r.Context()).Warn("Failed to decode gateway connect request", "err", err)
 		writeErrorResponse(w, connect.EmptyInteractionLogs(), err.Error())
 		return
 	}
-	client, il, err := state.newGatewayClient(r.Context(), status.Settings)
-	logger := il.Enter("status")
+
+	ctx := utils.WithLogAttrs(r.Context(), "operation", status.Payment.OperationType, "token", status.Payment.Token)
+	if status.Payment.GatewayToken != nil {
+		ctx = utils.WithLogAttrs(ctx, "gateway_id", *status.Payment.GatewayToken)
+	}
+	r = r.WithContext(ctx)
+	logger := utils.Logger(r.Context())
+	// token, operation and gateway id are carried by the context, settings hold the password
+	logger.Info("Status request")
+
+	il := connect.NewInteractionLogs(utils.RequestID(r.Context()))
+	client, err := state.newGatewayClient(r.Context(), status.Settings, &il)
+	span := il.Enter("status")
 	if err != nil {
-		log.Printf("Failed to initiate gateway client: %v", err)
-		writeErrorResponse(w, il, err.Error())
+		logger.Error("Failed to initiate gateway client", "err", err)
+		state.saveInteractionLogs(r.Context(), &il, status.Payment.Token, status.Payment.GatewayToken)
+		writeGatewayErrorResponse(w, il, err)
 		return
 	}
 
+	var update statusUpdate
 	switch status.Payment.OperationType {
-	case "pay":
-		res, err := client.RequestPaymentStatus(status, logger)
+	case connect.OperationPay:
+		providerStatus, err := client.RequestPaymentStatus(r.Context(), status, span)
 		if err != nil {
-			writeErrorResponse(w, il, err.Error())
+			logger.Error("Failed to request payment status", "err", err)
+			state.saveInteractionLogs(r.Context(), &il, status.Payment.Token, status.Payment.GatewayToken)
+			writeGatewayErrorResponse(w, il, err)
 			return
 		}
-		defer res.Body.Close()
-
-		body := utils.DecodeBody(res.Body, logger)
-		if res.StatusCode == http.StatusOK {
-			providerStatus, err := utils.UnmarshalBytes[gateway.PaymentStatusResponse](body)
-			// json deserialization error
-			if err != nil {
-				writeErrorResponse(w, il, err.Error())
-				return
-			}
-
-			// missing required fields
-			if providerStatus.ID == nil || providerStatus.Amount == nil {
-				writeErrorResponse(w, il, "Incorrect provider response")
-				return
-			}
-
-			utils.WriteJSON(
-				w,
-				connect.StatusResponse{
-					Result: true,
-					Logs:   il.IntoInner(),
-					Status: providerStatus.Status.Name.ToRPStatus(),
-					Amount: uint(*providerStatus.Amount * 100),
-				},
-			)
-		} else {
-			writeErrorResponse(w, il, gatewayErrorMessage(body))
+		update = statusUpdate{
+			gatewayID:      *providerStatus.ID,
+			operationType:  connect.OperationPay,
+			providerStatus: string(providerStatus.Status.Name),
+			rpStatus:       providerStatus.Status.Name.ToRPStatus(),
+			amount:         providerStatus.Amount,
+			source:         transitionSourceStatusCheck,
 		}
-	case "payout":
-		res, err := client.RequestPayoutStatus(status, logger)
+	case connect.OperationPayout:
+		providerStatus, err := client.RequestPayoutStatus(r.Context(), status, span)
 		if err != nil {
-			writeErrorResponse(w, il, err.Error())
+			logger.Error("Failed to request payout status", "err", err)
+			state.saveInteractionLogs(r.Context(), &il, status.Payment.Token, status.Payment.GatewayToken)
+			writeGatewayErrorResponse(w, il, err)
 			return
 		}
-		defer res.Body.Close()
-
-		body := utils.DecodeBody(res.Body, logger)
-		if res.StatusCode == http.StatusOK {
-			providerStatus, err := utils.UnmarshalBytes[gateway.PayoutStatusResponse](body)
-			// json deserialization error
-			if err != nil {
-				writeErrorResponse(w, il, err.Error())
-				return
-			}
-
-			// missing required fields
-			if providerStatus.ID == nil || providerStatus.Amount == nil {
-				writeErrorResponse(w, il, "Incorrect provider response")
-				return
-			}
-
-			utils.WriteJSON(
-				w,
-				connect.StatusResponse{
-					Result: true,
-					Logs:   il.IntoInner(),
-					Status: providerStatus.Status.Name.ToRPStatus(),
-					Amount: uint(*providerStatus.Amount * 100),
-				},
-			)
-		} else {
-			writeErrorResponse(w, il, gatewayErrorMessage(body))
+		update = statusUpdate{
+			gatewayID:      *providerStatus.ID,
+			operationType:  connect.OperationPayout,
+			providerStatus: string(providerStatus.Status.Name),
+			rpStatus:       providerStatus.Status.Name.ToRPStatus(),
+			amount:         providerStatus.Amount,
+			source:         transitionSourceStatusCheck,
 		}
 	default:
-		log.Printf("WARN: Unsupported operation type: %s", status.Payment.OperationType)
+		logger.Warn("Unsupported operation type")
+		writeErrorResponse(w, il, fmt.Sprintf("Unsupported operation type: %s", status.Payment.OperationType))
+		return
+	}
+
+	state.saveInteractionLogs(r.Context(), &il, status.Payment.Token, &update.gatewayID)
+	decision, err := state.applyStatus(r.Context(), update, nil)
+	if err != nil {
+		logger.Error("Failed to apply status", "err", err)
 	}
+
+	utils.WriteJSON(
+		w,
+		connect.StatusResponse{
+			Result: true,
+			Logs:   il.IntoInner(),
+			Status: decision.rpStatus,
+			Amount: uint(*update.amount * 100),
+		},
+	)
 }
 
 func (state *ApiState) PaymentCallbackHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received payment gateway callback")
-	callback, err := utils.DecodeJSONRequest[gateway.PaymentCallback](r.Body, w)
+	r = r.WithContext(utils.WithLogAttrs(r.Context(), "operation", connect.OperationPay))
+	utils.Logger(r.Context()).Info("Received payment gateway callback")
+	body, ok := state.readVerifiedCallback(w, r)
+	if !ok {
+		return
+	}
+
+	callback, err := utils.UnmarshalBytes[gateway.PaymentCallback](body)
 	if err != nil {
-		callbackError(w, "failed to decode callback body", err)
+		callbackError(w, r, "failed to decode callback body", err)
 		return
 	}
 
 	if callback.ID == nil || callback.Amount == nil || callback.Status == nil {
-		callbackError(w, "missing fields in gateway callback", fmt.Errorf("invalid payload"))
+		callbackError(w, r, "missing fields in gateway callback", fmt.Errorf("invalid payload"))
 		return
 	}
+	metrics.ProviderCallback(connect.OperationPay, callback.Status.ToRPStatus())
 
 	mapping, err := state.queries.GetMapping(r.Context(), *callback.ID)
 	if err != nil {
-		callbackError(w, "failed to load gateway token mapping", err)
+		callbackError(w, r, "failed to load gateway token mapping", err)
 		return
 	}
 
+	r = r.WithContext(utils.WithLogAttrs(r.Context(), "gateway_id", *callback.ID, "token", mapping.Token))
+	logger := utils.Logger(r.Context())
+
+	if state.needsStatusRefetch() {
+		providerStatus, err := state.fetchPaymentStatus(r.Context(), mapping.Token, *callback.ID)
+		if err != nil {
+			logger.Error("Failed to confirm payment callback status", "err", err)
+			http.Error(w, "failed to confirm callback status", http.StatusServiceUnavailable)
+			return
+		}
+		if providerStatus.Status.Name != *callback.Status {
+			logger.Warn("Payment callback status differs from provider status", "callback_status", *callback.Status, "provider_status", providerStatus.Status.Name)
+		}
+		callback.Status = &providerStatus.Status.Name
+		callback.Amount = providerStatus.Amount
+		callback.NewAmount = nil
+	}
+
 	amount := int(*callback.Amount * 100)
 
 	if callback.NewAmount != nil {
-		log.Printf("Got callback with updated amount: %.2f", *callback.NewAmount)
+		logger.Info("Got callback with updated amount", "new_amount", *callback.NewAmount)
 		amount = int(*callback.NewAmount * 100)
 	}
 
-	var reason *string
-	if callback.Status.ToRPStatus() == "declined" {
-		reason = (*string)(callback.Status)
-	}
-
-	state.sendGatewayCallback(w, r, gatewayCallbackParams{
-		gatewayID: *callback.ID,
-		Reason:    reason,
-		Token:     mapping.Token,
-		Status:    callback.Status.ToRPStatus(),
-		Amount:    amount,
+	decision, err := state.applyStatus(r.Context(), statusUpdate{
+		gatewayID:      *callback.ID,
+		operationType:  connect.OperationPay,
+		providerStatus: string(*callback.Status),
+		rpStatus:       callback.Status.ToRPStatus(),
+		amount:         callback.Amount,
+		source:         transitionSourceCallback,
+	}, func(decision statusDecision) *gatewayCallbackParams {
+		var reason *string
+		if decision.rpStatus == "declined" {
+			reason = (*string)(callback.Status)
+		}
+		return &gatewayCallbackParams{
+			gatewayID: *callback.ID,
+			Reason:    reason,
+			Token:     mapping.Token,
+			Status:    decision.rpStatus,
+			Amount:    amount,
+		}
 	})
+	if err != nil {
+		// Nothing was committed, the provider retries the callback
+		logger.Error("Failed to apply payment callback status", "err", err)
+		http.Error(w, "failed to apply callback status", http.StatusInternalServerError)
+		return
+	}
+	if !decision.forward {
+		logger.Info("Payment callback does not change business status, not forwarding")
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
 func (state *ApiState) PayoutCallbackHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received payment gateway callback")
-	callback, err := utils.DecodeJSONRequest[gateway.PayoutCallback](r.Body, w)
+	r = r.WithContext(utils.WithLogAttrs(r.Context(), "operation", connect.OperationPayout))
+	utils.Logger(r.Context()).Info("Received payout gateway callback")
+	body, ok := state.readVerifiedCallback(w, r)
+	if !ok {
+		return
+	}
+
+	callback, err := utils.UnmarshalBytes[gateway.PayoutCallback](body)
 	if err != nil {
-		callbackError(w, "failed to decode callback body", err)
+		callbackError(w, r, "failed to decode callback body", err)
 		return
 	}
 
 	if callback.PayoutID == nil || callback.PayoutAmount == nil || callback.PayoutStatus == nil {
-		callbackError(w, "missing fields in gateway callback", fmt.Errorf("invalid payload"))
+		callbackError(w, r, "missing fields in gateway callback", fmt.Errorf("invalid payload"))
 		return
 	}
+	metrics.ProviderCallback(connect.OperationPayout, callback.PayoutStatus.ToRPStatus())
 
 	mapping, err := state.queries.GetMapping(r.Context(), *callback.PayoutID)
 	if err != nil {
-		callbackError(w, "failed to load gateway token mapping", err)
+		callbackError(w, r, "failed to load gateway token mapping", err)
 		return
 	}
 
+	r = r.WithContext(utils.WithLogAttrs(r.Context(), "gateway_id", *callback.PayoutID, "token", mapping.Token))
+	logger := utils.Logger(r.Context())
+
+	if state.needsStatusRefetch() {
+		providerStatus, err := state.fetchPayoutStatus(r.Context(), mapping.Token, *callback.PayoutID)
+		if err != nil {
+			logger.Error("Failed to confirm payout callback status", "err", err)
+			http.Error(w, "failed to confirm callback status", http.StatusServiceUnavailable)
+			return
+		}
+		if providerStatus.Status.Name != *callback.PayoutStatus {
+			logger.Warn("Payout callback status differs from provider status", "callback_status", *callback.PayoutStatus, "provider_status", providerStatus.Status.Name)
+		}
+		callback.PayoutStatus = &providerStatus.Status.Name
+		callback.PayoutAmount = providerStatus.Amount
+	}
+
 	amount := int(*callback.PayoutAmount * 100)
 
 	if callback.PayoutAmount != nil {
 		amount = int(*callback.PayoutAmount * 100)
 	}
 
-	var reason *string
-	if callback.PayoutStatus.ToRPStatus() == "declined" {
-		reason = (*string)(callback.PayoutStatus)
-	}
-
-	state.sendGatewayCallback(w, r, gatewayCallbackParams{
-		gatewayID: *callback.PayoutID,
-		Reason:    reason,
-		Token:     mapping.Token,
-		Status:    callback.PayoutStatus.ToRPStatus(),
-		Amount:    amount,
+	decision, err := state.applyStatus(r.Context(), statusUpdate{
+		gatewayID:      *callback.PayoutID,
+		operationType:  connect.OperationPayout,
+		providerStatus: string(*callback.PayoutStatus),
+		rpStatus:       callback.PayoutStatus.ToRPStatus(),
+		amount:         callback.PayoutAmount,
+		source:         transitionSourceCallback,
+	}, func(decision statusDecision) *gatewayCallbackParams {
+		var reason *string
+		if decision.rpStatus == "declined" {
+			reason = (*string)(callback.PayoutStatus)
+		}
+		return &gatewayCallbackParams{
+			gatewayID: *callback.PayoutID,
+			Reason:    reason,
+			Token:     mapping.Token,
+			Status:    decision.rpStatus,
+			Amount:    amount,
+		}
 	})
+	if err != nil {
+		// Nothing was committed, the provider retries the callback
+		logger.Error("Failed to apply payout callback status", "err", err)
+		http.Error(w, "failed to apply callback status", http.StatusInternalServerError)
+		return
+	}
+	if !decision.forward {
+		logger.Info("Payout callback does not change business status, not forwarding")
+	}
+	w.WriteHeader(http.StatusOK)
 }
@@ -1,17 +1,19 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"time"
 
 	"github.com/dog4ik/stbl/connect"
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/utils"
 )
 
-func callbackError(w http.ResponseWriter, msg string, err error) {
-	log.Printf("ERROR: %s: %v", msg, err)
+func callbackError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	utils.Logger(r.Context()).Error(msg, "err", err)
 	http.Error(w, msg, http.StatusBadRequest)
 }
 
@@ -23,17 +25,8 @@ type gatewayCallbackParams struct {
 	Token     string
 }
 
-func (state *ApiState) sendGatewayCallback(
-	w http.ResponseWriter,
-	r *http.Request,
-	params gatewayCallbackParams,
-) {
-	mapping, err := state.queries.GetMapping(r.Context(), params.gatewayID)
-	if err != nil {
-		callbackError(w, "failed to load gateway token mapping", err)
-		return
-	}
-
+// Persist the business callback in the outbox, delivery itself happens in the callback worker
+func enqueueCallback(ctx context.Context, queries *db.Store, params gatewayCallbackParams) error {
 	payload := connect.CallbackPayload{
 		Currency: "ARS",
 		Status:   params.Status,
@@ -41,42 +34,22 @@ func (state *ApiState) sendGatewayCallback(
 		Reason:   params.Reason,
 	}
 
-	jwt, err := connect.CreateJWT(payload, mapping.MerchantPrivateKey, []byte(state.signKey))
-	if err != nil {
-		callbackError(w, "failed to create JWT", err)
-		return
-	}
-
-	jsonPayload, _ := json.Marshal(payload)
-
-	url := fmt.Sprintf(
-		"%s/callbacks/v2/gateway_callbacks/%s",
-		state.businessUrl,
-		params.Token,
-	)
-
-	log.Printf("Sending gateway connect callback(%s) payload: %s", url, jsonPayload)
-
-	req, err := http.NewRequestWithContext(
-		r.Context(),
-		http.MethodPost,
-		url,
-		bytes.NewReader(jsonPayload),
-	)
+	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
-		callbackError(w, "failed to create callback request", err)
-		return
+		return fmt.Errorf("failed to encode callback payload: %w", err)
 	}
 
-	req.Header.Set("content-type", "application/json")
-	req.Header.Set("authorization", "Bearer "+jwt)
-
-	res, err := state.client.Do(req)
+	entry, err := queries.EnqueueCallback(ctx, db.EnqueueCallbackParams{
+		GatewayID:     params.gatewayID,
+		Token:         params.Token,
+		Payload:       string(jsonPayload),
+		NextAttemptAt: time.Now().UTC(),
+		RequestID:     utils.RequestID(ctx),
+	})
 	if err != nil {
-		callbackError(w, "failed to send callback", err)
-		return
+		return fmt.Errorf("failed to enqueue callback: %w", err)
 	}
-	defer res.Body.Close()
 
-	log.Printf("Gateway connect callback response: %s", res.Status)
+	utils.Logger(ctx).Info("Enqueued gateway connect callback", "outbox_id", entry.ID, "payload", string(jsonPayload))
+	return nil
 }
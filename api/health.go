@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/utils"
+)
+
+type readinessConfig struct {
+	// Probe provider base urls in addition to the database
+	probeProvider bool
+	timeout       time.Duration
+}
+
+type dependencyCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                     `json:"status"`
+	Checks map[string]dependencyCheck `json:"checks,omitempty"`
+}
+
+func checkResult(err error) dependencyCheck {
+	if err != nil {
+		return dependencyCheck{Status: "fail", Error: err.Error()}
+	}
+	return dependencyCheck{Status: "ok"}
+}
+
+// Process liveness, does not touch any dependency
+func (state *ApiState) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, healthResponse{Status: "ok"})
+}
+
+// Readiness to serve traffic with a breakdown per dependency, 503 if any of them fails
+func (state *ApiState) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), state.readiness.timeout)
+	defer cancel()
+
+	checks := map[string]dependencyCheck{
+		"database": checkResult(state.conn.PingContext(ctx)),
+		"schema":   checkResult(db.CheckSchema(ctx, state.conn, state.queries.Dialect())),
+	}
+
+	if state.readiness.probeProvider {
+		checks["provider"] = checkResult(state.probeUrl(ctx, state.gatewayConfig.ProdBaseUrl))
+		if state.gatewayConfig.SandboxBaseUrl != state.gatewayConfig.ProdBaseUrl {
+			checks["sandbox_provider"] = checkResult(state.probeUrl(ctx, state.gatewayConfig.SandboxBaseUrl))
+		}
+	}
+
+	response := healthResponse{Status: "ok", Checks: checks}
+	for name, check := range checks {
+		if check.Status != "ok" {
+			utils.Logger(r.Context()).Warn("Readiness check failed", "dependency", name, "err", check.Error)
+			response.Status = "fail"
+		}
+	}
+
+	if response.Status != "ok" {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	utils.WriteJSON(w, response)
+}
+
+// Any HTTP response means the provider is reachable, the status code does not matter
+func (state *ApiState) probeUrl(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := state.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
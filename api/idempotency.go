@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/dog4ik/stbl/connect"
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/utils"
+)
+
+// Per connect token mutexes, so duplicate requests for the same token run one after another
+type tokenLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tokenLock
+}
+
+type tokenLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newTokenLocks() tokenLocks {
+	return tokenLocks{locks: make(map[string]*tokenLock)}
+}
+
+// Lock the token and return the function that releases it
+func (self *tokenLocks) lock(token string) func() {
+	self.mu.Lock()
+	lock, ok := self.locks[token]
+	if !ok {
+		lock = &tokenLock{}
+		self.locks[token] = lock
+	}
+	lock.refs++
+	self.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		self.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(self.locks, token)
+		}
+		self.mu.Unlock()
+	}
+}
+
+// Answer a repeated request with what the first request for the token produced
+func writeStoredSubmission(w http.ResponseWriter, transaction db.Transaction, operationType string, fallbackRedirect string) {
+	il := connect.EmptyInteractionLogs()
+
+	if transaction.OperationType != operationType {
+		writeErrorResponse(w, il, fmt.Sprintf("Token is already used by %s operation", transaction.OperationType))
+		return
+	}
+
+	redirect := fallbackRedirect
+	if transaction.RedirectUrl.Valid {
+		redirect = transaction.RedirectUrl.String
+	}
+
+	if !transaction.GatewayID.Valid {
+		if transaction.RpStatus == "declined" {
+			writeErrorResponse(w, il, "Operation with this token was already declined")
+		} else {
+			writePayoutPendingResponse(w, il, connect.NewGetRedirect(redirect))
+		}
+		return
+	}
+
+	utils.WriteJSON(
+		w,
+		connect.PayoutResponse{
+			Result:          true,
+			Logs:            il.IntoInner(),
+			RedirectRequest: connect.NewGetRedirect(redirect),
+			Status:          transaction.RpStatus,
+			GatewayToken:    &transaction.GatewayID.String,
+		},
+	)
+}
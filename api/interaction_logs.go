@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dog4ik/stbl/connect"
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/utils"
+)
+
+type interactionLogConfig struct {
+	// How long interaction logs are kept, zero keeps them forever
+	retention time.Duration
+	// How often expired logs are deleted
+	purgeInterval time.Duration
+}
+
+// Persist provider interactions made for the connect token, closes the current span.
+// Logs are kept even if the caller has gone away, they are the record of what was sent to the provider.
+func (state *ApiState) saveInteractionLogs(ctx context.Context, il *connect.InteractionLogs, token string, gatewayID *string) {
+	ctx = context.WithoutCancel(ctx)
+
+	var storedGatewayID sql.NullString
+	if gatewayID != nil {
+		storedGatewayID = nullString(*gatewayID)
+	}
+
+	logs := il.Finish()
+	if len(logs) == 0 {
+		return
+	}
+
+	if err := state.queries.InTx(ctx, func(queries *db.Store) error {
+		for _, log := range logs {
+			if err := queries.CreateInteractionLog(ctx, interactionLogParams(log, token, storedGatewayID)); err != nil {
+				return fmt.Errorf("%s: %w", log.Kind, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		utils.Logger(ctx).Error("Failed to persist interaction logs", "err", err)
+	}
+}
+
+func interactionLogParams(log connect.InteractionLog, token string, gatewayID sql.NullString) db.CreateInteractionLogParams {
+	params := db.CreateInteractionLogParams{
+		Token:     token,
+		GatewayID: gatewayID,
+		RequestID: log.RequestID,
+		Kind:      log.Kind,
+		Duration:  log.Duration,
+		CreatedAt: log.CreatedAt.UTC(),
+	}
+	if log.Request != nil {
+		params.RequestUrl = nullString(log.Request.URL)
+		params.RequestParams = nullString(log.Request.Params)
+	}
+	if log.Status != nil {
+		params.Status = sql.NullInt64{Int64: int64(*log.Status), Valid: true}
+	}
+	if log.Response != nil {
+		params.Response = sql.NullString{String: *log.Response, Valid: true}
+	}
+	return params
+}
+
+// Persisted interaction log with the operation it belongs to
+type storedInteractionLog struct {
+	Token     string  `json:"token"`
+	GatewayID *string `json:"gateway_id,omitempty"`
+	connect.InteractionLog
+}
+
+func newStoredInteractionLog(log db.InteractionLog) storedInteractionLog {
+	stored := storedInteractionLog{
+		Token: log.Token,
+		InteractionLog: connect.InteractionLog{
+			Gateway:   "stbl",
+			Kind:      log.Kind,
+			CreatedAt: log.CreatedAt,
+			Duration:  log.Duration,
+			RequestID: log.RequestID,
+		},
+	}
+	if log.GatewayID.Valid {
+		stored.GatewayID = &log.GatewayID.String
+	}
+	if log.RequestUrl.Valid || log.RequestParams.Valid {
+		stored.Request = &connect.Request{URL: log.RequestUrl.String, Params: log.RequestParams.String}
+	}
+	if log.Status.Valid {
+		status := int(log.Status.Int64)
+		stored.Status = &status
+	}
+	if log.Response.Valid {
+		stored.Response = &log.Response.String
+	}
+	return stored
+}
+
+// Full provider interaction history of a connect token or gateway id
+func (state *ApiState) InteractionLogsHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	gatewayID := r.URL.Query().Get("gateway_id")
+	if token == "" && gatewayID == "" {
+		http.Error(w, "token or gateway_id is required", http.StatusBadRequest)
+		return
+	}
+
+	// Spans made before the provider assigned the id are stored under the token only
+	if token == "" {
+		if mapping, err := state.queries.GetMapping(r.Context(), gatewayID); err == nil {
+			token = mapping.Token
+		}
+	}
+
+	logs, err := state.listInteractionLogs(r.Context(), token, gatewayID)
+	if err != nil {
+		utils.Logger(r.Context()).Error("Failed to list interaction logs", "err", err)
+		http.Error(w, "failed to list interaction logs", http.StatusInternalServerError)
+		return
+	}
+
+	utils.WriteJSON(w, logs)
+}
+
+func (state *ApiState) listInteractionLogs(ctx context.Context, token string, gatewayID string) ([]storedInteractionLog, error) {
+	logs, err := state.queries.ListInteractionLogs(ctx, db.ListInteractionLogsParams{
+		Token:     token,
+		GatewayID: nullString(gatewayID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make([]storedInteractionLog, 0, len(logs))
+	for _, log := range logs {
+		stored = append(stored, newStoredInteractionLog(log))
+	}
+	return stored, nil
+}
+
+// Delete interaction logs and callback rejections older than the retention period until the context is cancelled
+func (state *ApiState) RunInteractionLogPurge(ctx context.Context) {
+	if state.interactionLogs.retention <= 0 || state.interactionLogs.purgeInterval <= 0 {
+		utils.Logger(ctx).Info("Interaction log purge is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(state.interactionLogs.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		state.purgeInteractionLogs(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (state *ApiState) purgeInteractionLogs(ctx context.Context) {
+	cutoff := time.Now().UTC().Add(-state.interactionLogs.retention)
+	purged, err := state.queries.PurgeInteractionLogs(ctx, cutoff)
+	if err != nil {
+		utils.Logger(ctx).Error("Failed to purge interaction logs", "err", err)
+		return
+	}
+	if purged > 0 {
+		utils.Logger(ctx).Info("Purged expired interaction logs", "count", purged, "before", cutoff)
+	}
+
+	// Rejected callbacks can be sent by anyone who reaches the port, they expire with the logs
+	purged, err = state.queries.PurgeCallbackRejections(ctx, cutoff)
+	if err != nil {
+		utils.Logger(ctx).Error("Failed to purge callback rejections", "err", err)
+		return
+	}
+	if purged > 0 {
+		utils.Logger(ctx).Info("Purged expired callback rejections", "count", purged, "before", cutoff)
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dog4ik/stbl/connect"
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/utils"
+)
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func nullFloat(value *float64) sql.NullFloat64 {
+	if value == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *value, Valid: true}
+}
+
+// Record requested operation in the transaction ledger before it is sent to the provider.
+// Returns the stored transaction if the token was already submitted. The token is locked
+// while the ledger is checked, so only one instance ever sends the operation to the provider.
+func (state *ApiState) recordTransaction(ctx context.Context, operationType string, request connect.PayoutRequest) (*db.Transaction, error) {
+	var amount int64
+	if request.Payment.GatewayAmount != nil {
+		amount = int64(*request.Payment.GatewayAmount)
+	}
+
+	var currency sql.NullString
+	if request.Payment.GatewayCurrency != nil {
+		currency = nullString(*request.Payment.GatewayCurrency)
+	}
+
+	var existing *db.Transaction
+	err := state.queries.InTx(ctx, func(queries *db.Store) error {
+		if err := queries.LockKey(ctx, "token:"+request.Payment.Token); err != nil {
+			return err
+		}
+
+		transaction, err := queries.GetTransactionByToken(ctx, request.Payment.Token)
+		if err == nil {
+			existing = &transaction
+			return nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to load transaction: %w", err)
+		}
+
+		if _, err := queries.CreateTransaction(ctx, db.CreateTransactionParams{
+			Token:         request.Payment.Token,
+			OperationType: operationType,
+			Amount:        amount,
+			Currency:      currency,
+			RpStatus:      "pending",
+			Sandbox:       request.Settings.Sandbox,
+			ExternalID:    request.Payment.Token,
+			Now:           time.Now().UTC(),
+		}); err != nil {
+			return fmt.Errorf("failed to record transaction: %w", err)
+		}
+		return nil
+	})
+	return existing, err
+}
+
+// Operation the provider created
+type submission struct {
+	gatewayID      string
+	providerAmount float64
+	providerStatus string
+	rpStatus       string
+	redirectUrl    string
+}
+
+// Record provider's answer to the created operation. The token mapping, merchant settings
+// and ledger entry are written together, so a created operation is either fully recorded
+// or still pending without a gateway id.
+func (state *ApiState) recordSubmission(ctx context.Context, request connect.PayoutRequest, submission submission) {
+	// The provider already created the operation, record it even if the caller is gone
+	ctx = context.WithoutCancel(ctx)
+
+	if err := state.queries.InTx(ctx, func(queries *db.Store) error {
+		if _, err := queries.CreateMapping(ctx, db.CreateMappingParams{
+			Token:              request.Payment.Token,
+			MerchantPrivateKey: request.Payment.MerchantPrivateKey,
+			GatewayID:          submission.gatewayID,
+		}); err != nil {
+			return fmt.Errorf("failed to insert gateway token mapping: %w", err)
+		}
+
+		if err := queries.CreateGatewaySettings(ctx, db.CreateGatewaySettingsParams{
+			GatewayID: submission.gatewayID,
+			Login:     request.Settings.Login,
+			Password:  request.Settings.Password,
+			Sandbox:   request.Settings.Sandbox,
+		}); err != nil {
+			return fmt.Errorf("failed to insert gateway settings: %w", err)
+		}
+
+		if err := queries.SetTransactionGateway(ctx, db.SetTransactionGatewayParams{
+			GatewayID:      nullString(submission.gatewayID),
+			ProviderAmount: nullFloat(&submission.providerAmount),
+			ProviderStatus: nullString(submission.providerStatus),
+			RpStatus:       submission.rpStatus,
+			RedirectUrl:    nullString(submission.redirectUrl),
+			UpdatedAt:      time.Now().UTC(),
+			Token:          request.Payment.Token,
+		}); err != nil {
+			return fmt.Errorf("failed to record transaction submission: %w", err)
+		}
+		return nil
+	}); err != nil {
+		utils.Logger(ctx).Error("Failed to record transaction submission", "err", err)
+	}
+}
+
+// Record operation that was rejected before the provider created it
+func (state *ApiState) recordDecline(ctx context.Context, token string) {
+	if err := state.queries.SetTransactionRPStatus(ctx, db.SetTransactionRPStatusParams{
+		RpStatus:  "declined",
+		UpdatedAt: time.Now().UTC(),
+		Token:     token,
+	}); err != nil {
+		utils.Logger(ctx).Error("Failed to record transaction decline", "err", err)
+	}
+}
+
+// Record status reported by a callback or a status check
+func recordStatus(ctx context.Context, queries *db.Store, gatewayID string, providerStatus string, providerAmount *float64, rpStatus string) error {
+	if err := queries.UpdateTransactionStatus(ctx, db.UpdateTransactionStatusParams{
+		ProviderStatus: nullString(providerStatus),
+		ProviderAmount: nullFloat(providerAmount),
+		RpStatus:       rpStatus,
+		UpdatedAt:      time.Now().UTC(),
+		GatewayID:      nullString(gatewayID),
+	}); err != nil {
+		return fmt.Errorf("failed to record transaction status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dog4ik/stbl/utils"
+)
+
+// Inbound correlation ids longer than this are replaced with a generated one
+const maxRequestIDLength = 128
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Attach correlation id to every request, reusing the caller's X-Request-ID when present.
+// The id is echoed back in the response and carried by the request context logger.
+func WithRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(utils.RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = utils.NewRequestID()
+		}
+
+		ctx := utils.WithRequestID(r.Context(), requestID)
+		w.Header().Set(utils.RequestIDHeader, requestID)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		r = r.WithContext(ctx)
+		next.ServeHTTP(recorder, r)
+
+		// request path is not logged, callback paths may carry a secret
+		utils.Logger(ctx).Info(
+			"Handled request",
+			"method", r.Method,
+			"pattern", r.Pattern,
+			"status", recorder.status,
+			"duration", time.Since(start),
+		)
+	})
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dog4ik/stbl/connect"
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/metrics"
+	"github.com/dog4ik/stbl/utils"
+)
+
+const (
+	outboxStatusPending   = "pending"
+	outboxStatusDelivered = "delivered"
+	outboxStatusDead      = "dead"
+)
+
+const (
+	// How often the worker looks for due callbacks when nobody wakes it up
+	outboxPollInterval = 5 * time.Second
+	// How long a claimed callback is hidden from other workers while it is being delivered
+	outboxLeaseDuration = time.Minute
+	outboxBatchSize     = 50
+)
+
+type outboxConfig struct {
+	maxAttempts      int
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+}
+
+// Exponential backoff for the given number of failed attempts
+func (config outboxConfig) backoff(attempts int64) time.Duration {
+	delay := config.retryInterval
+	for i := int64(1); i < attempts; i++ {
+		delay *= 2
+		if delay >= config.maxRetryInterval {
+			return config.maxRetryInterval
+		}
+	}
+	return min(delay, config.maxRetryInterval)
+}
+
+func (state *ApiState) wakeCallbackWorker() {
+	select {
+	case state.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// Deliver callbacks that are due right now, used to flush the outbox on shutdown
+func (state *ApiState) DrainCallbacks(ctx context.Context) {
+	state.deliverDueCallbacks(ctx)
+}
+
+// Deliver outbox callbacks until the context is cancelled
+func (state *ApiState) RunCallbackWorker(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		state.deliverDueCallbacks(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-state.outboxWake:
+		}
+	}
+}
+
+func (state *ApiState) deliverDueCallbacks(ctx context.Context) {
+	for {
+		now := time.Now().UTC()
+		due, err := state.queries.ListDueCallbacks(ctx, db.ListDueCallbacksParams{
+			NextAttemptAt: now,
+			Limit:         outboxBatchSize,
+		})
+		if err != nil {
+			utils.Logger(ctx).Error("Failed to list due callbacks", "err", err)
+			return
+		}
+
+		for _, entry := range due {
+			if ctx.Err() != nil {
+				return
+			}
+
+			claimed, err := state.queries.ClaimCallback(ctx, db.ClaimCallbackParams{
+				LeaseUntil: now.Add(outboxLeaseDuration),
+				ID:         entry.ID,
+				Now:        now,
+			})
+			if err != nil {
+				utils.Logger(ctx).Error("Failed to claim callback", "outbox_id", entry.ID, "err", err)
+				continue
+			}
+			if claimed == 0 {
+				continue
+			}
+
+			state.processCallback(ctx, entry)
+		}
+
+		if len(due) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func (state *ApiState) processCallback(ctx context.Context, entry db.CallbackOutbox) {
+	if entry.RequestID != "" {
+		ctx = utils.WithRequestID(ctx, entry.RequestID)
+	}
+	ctx = utils.WithLogAttrs(ctx,
+		"outbox_id", entry.ID,
+		"token", entry.Token,
+		"gateway_id", entry.GatewayID,
+	)
+	logger := utils.Logger(ctx)
+
+	deliveryErr := state.deliverCallback(ctx, entry)
+	now := time.Now().UTC()
+	// Record the outcome even if the worker is being stopped
+	ctx = context.WithoutCancel(ctx)
+
+	if deliveryErr == nil {
+		logger.Info("Delivered gateway connect callback")
+		metrics.BusinessCallback(metrics.CallbackDelivered)
+		if err := state.queries.MarkCallbackDelivered(ctx, db.MarkCallbackDeliveredParams{
+			UpdatedAt: now,
+			ID:        entry.ID,
+		}); err != nil {
+			logger.Error("Failed to mark callback as delivered", "err", err)
+		}
+		return
+	}
+
+	attempts := entry.Attempts + 1
+	status := outboxStatusPending
+	nextAttempt := now.Add(state.outbox.backoff(attempts))
+	if attempts >= int64(state.outbox.maxAttempts) {
+		status = outboxStatusDead
+		metrics.BusinessCallback(metrics.CallbackDead)
+		logger.Error("Giving up on gateway connect callback", "attempts", attempts, "err", deliveryErr)
+	} else {
+		metrics.BusinessCallback(metrics.CallbackRetry)
+		logger.Warn("Failed to deliver gateway connect callback", "attempts", attempts, "next_attempt_at", nextAttempt, "err", deliveryErr)
+	}
+
+	if err := state.queries.MarkCallbackFailed(ctx, db.MarkCallbackFailedParams{
+		Status:        status,
+		LastError:     sql.NullString{String: deliveryErr.Error(), Valid: true},
+		NextAttemptAt: nextAttempt,
+		UpdatedAt:     now,
+		ID:            entry.ID,
+	}); err != nil {
+		logger.Error("Failed to record callback failure", "err", err)
+	}
+}
+
+func (state *ApiState) deliverCallback(ctx context.Context, entry db.CallbackOutbox) error {
+	mapping, err := state.queries.GetMapping(ctx, entry.GatewayID)
+	if err != nil {
+		return fmt.Errorf("failed to load gateway token mapping: %w", err)
+	}
+
+	var payload connect.CallbackPayload
+	if err := json.Unmarshal([]byte(entry.Payload), &payload); err != nil {
+		return fmt.Errorf("failed to decode callback payload: %w", err)
+	}
+
+	jwt, err := connect.CreateJWT(payload, mapping.MerchantPrivateKey, []byte(state.signKey))
+	if err != nil {
+		return fmt.Errorf("failed to create JWT: %w", err)
+	}
+
+	url := fmt.Sprintf(
+		"%s/callbacks/v2/gateway_callbacks/%s",
+		state.businessUrl,
+		entry.Token,
+	)
+
+	utils.Logger(ctx).Info("Sending gateway connect callback", "url", url, "payload", entry.Payload)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		url,
+		bytes.NewReader([]byte(entry.Payload)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create callback request: %w", err)
+	}
+
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("authorization", "Bearer "+jwt)
+	// Business can match the callback with the request that caused it
+	if entry.RequestID != "" {
+		req.Header.Set(utils.RequestIDHeader, entry.RequestID)
+	}
+
+	res, err := state.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send callback: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	utils.Logger(ctx).Info("Gateway connect callback response", "status", res.Status)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", res.Status)
+	}
+
+	return nil
+}
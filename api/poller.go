@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/dog4ik/stbl/connect"
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/utils"
+)
+
+const (
+	transitionSourcePoll = "poll"
+	pollerBatchSize      = 100
+)
+
+type pollerConfig struct {
+	// How long a pending transaction waits between status requests, zero disables polling
+	interval time.Duration
+	// Transactions older than this are left alone
+	maxAge time.Duration
+	// Status requests in flight at once
+	concurrency int
+}
+
+// Poll provider for transactions stuck in pending until the context is cancelled
+func (state *ApiState) RunStatusPoller(ctx context.Context) {
+	if state.poller.interval <= 0 {
+		utils.Logger(ctx).Info("Status poller is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(state.poller.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			state.pollPendingTransactions(ctx)
+		}
+	}
+}
+
+func (state *ApiState) pollPendingTransactions(ctx context.Context) {
+	now := time.Now().UTC()
+	staleBefore := sql.NullTime{Time: now.Add(-state.poller.interval), Valid: true}
+
+	pending, err := state.queries.ListPendingTransactions(ctx, db.ListPendingTransactionsParams{
+		CreatedAfter: now.Add(-state.poller.maxAge),
+		StaleBefore:  staleBefore,
+		Limit:        pollerBatchSize,
+	})
+	if err != nil {
+		utils.Logger(ctx).Error("Failed to list pending transactions", "err", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(state.poller.concurrency, 1))
+
+	for _, transaction := range pending {
+		claimed, err := state.queries.ClaimTransactionPoll(ctx, db.ClaimTransactionPollParams{
+			Now:         sql.NullTime{Time: now, Valid: true},
+			ID:          transaction.ID,
+			StaleBefore: staleBefore,
+		})
+		if err != nil {
+			utils.Logger(ctx).Error("Failed to claim transaction for polling", "token", transaction.Token, "err", err)
+			continue
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case slots <- struct{}{}:
+		}
+
+		wg.Go(func() {
+			defer func() { <-slots }()
+			state.pollTransaction(ctx, transaction)
+		})
+	}
+
+	wg.Wait()
+}
+
+func (state *ApiState) pollTransaction(ctx context.Context, transaction db.Transaction) {
+	gatewayID := transaction.GatewayID.String
+	// Every poll gets its own correlation id, shared by the status request and the callback it produces
+	ctx = utils.WithRequestID(ctx, utils.NewRequestID())
+	ctx = utils.WithLogAttrs(ctx,
+		"token", transaction.Token,
+		"gateway_id", gatewayID,
+		"operation", transaction.OperationType,
+	)
+	logger := utils.Logger(ctx)
+
+	var update statusUpdate
+	switch transaction.OperationType {
+	case connect.OperationPay:
+		providerStatus, err := state.fetchPaymentStatus(ctx, transaction.Token, gatewayID)
+		if err != nil {
+			logger.Warn("Failed to poll payment status", "err", err)
+			return
+		}
+		update = statusUpdate{
+			gatewayID:      gatewayID,
+			operationType:  connect.OperationPay,
+			providerStatus: string(providerStatus.Status.Name),
+			rpStatus:       providerStatus.Status.Name.ToRPStatus(),
+			amount:         providerStatus.Amount,
+			source:         transitionSourcePoll,
+		}
+	case connect.OperationPayout:
+		providerStatus, err := state.fetchPayoutStatus(ctx, transaction.Token, gatewayID)
+		if err != nil {
+			logger.Warn("Failed to poll payout status", "err", err)
+			return
+		}
+		update = statusUpdate{
+			gatewayID:      gatewayID,
+			operationType:  connect.OperationPayout,
+			providerStatus: string(providerStatus.Status.Name),
+			rpStatus:       providerStatus.Status.Name.ToRPStatus(),
+			amount:         providerStatus.Amount,
+			source:         transitionSourcePoll,
+		}
+	default:
+		logger.Warn("Unsupported operation type")
+		return
+	}
+
+	_, err := state.applyStatus(ctx, update, func(decision statusDecision) *gatewayCallbackParams {
+		// Intermediate statuses are left for the provider callback
+		if !isFinalRPStatus(decision.rpStatus) {
+			return nil
+		}
+
+		var reason *string
+		if decision.rpStatus == "declined" {
+			reason = &update.providerStatus
+		}
+
+		logger.Info("Poller found final status", "provider_status", update.providerStatus)
+		return &gatewayCallbackParams{
+			gatewayID: gatewayID,
+			Status:    decision.rpStatus,
+			Amount:    int(*update.amount * 100),
+			Reason:    reason,
+			Token:     transaction.Token,
+		}
+	})
+	if err != nil {
+		logger.Error("Failed to apply polled status", "err", err)
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dog4ik/stbl/connect"
+	"github.com/dog4ik/stbl/gateway"
+	"github.com/dog4ik/stbl/utils"
+)
+
+// Gateway client authenticated with the settings stored for the gateway id
+func (state *ApiState) storedGatewayClient(ctx context.Context, gatewayID string, il *connect.InteractionLogs) (*gateway.GatewayClient, error) {
+	settings, err := state.queries.GetGatewaySettings(ctx, gatewayID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load gateway settings: %w", err)
+	}
+
+	return state.newGatewayClient(ctx, connect.Settings{
+		Login:    settings.Login,
+		Password: settings.Password,
+		Sandbox:  settings.Sandbox,
+	}, il)
+}
+
+func statusRequest(gatewayID string, operationType string) connect.StatusRequest {
+	return connect.StatusRequest{
+		Payment: connect.StatusPayment{
+			GatewayToken:  &gatewayID,
+			OperationType: operationType,
+		},
+	}
+}
+
+// Request authoritative payment status from the provider
+func (state *ApiState) fetchPaymentStatus(ctx context.Context, token string, gatewayID string) (gateway.PaymentStatusResponse, error) {
+	il := connect.NewInteractionLogs(utils.RequestID(ctx))
+	defer state.saveInteractionLogs(ctx, &il, token, &gatewayID)
+	client, err := state.storedGatewayClient(ctx, gatewayID, &il)
+	if err != nil {
+		return gateway.PaymentStatusResponse{}, err
+	}
+
+	return client.RequestPaymentStatus(ctx, statusRequest(gatewayID, connect.OperationPay), il.Enter("status"))
+}
+
+// Request authoritative payout status from the provider
+func (state *ApiState) fetchPayoutStatus(ctx context.Context, token string, gatewayID string) (gateway.PayoutStatusResponse, error) {
+	il := connect.NewInteractionLogs(utils.RequestID(ctx))
+	defer state.saveInteractionLogs(ctx, &il, token, &gatewayID)
+	client, err := state.storedGatewayClient(ctx, gatewayID, &il)
+	if err != nil {
+		return gateway.PayoutStatusResponse{}, err
+	}
+
+	return client.RequestPayoutStatus(ctx, statusRequest(gatewayID, connect.OperationPayout), il.Enter("status"))
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dog4ik/stbl/connect"
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/gateway"
+	"github.com/dog4ik/stbl/utils"
+)
+
+// Where the provider status came from
+const (
+	transitionSourceCallback    = "callback"
+	transitionSourceStatusCheck = "status_check"
+)
+
+type statusUpdate struct {
+	gatewayID      string
+	operationType  string
+	providerStatus string
+	rpStatus       string
+	amount         *float64
+	source         string
+}
+
+type statusDecision struct {
+	// Business has to be notified about the new status
+	forward bool
+	// Status the business should see after the update
+	rpStatus string
+}
+
+func statusMachine(operationType string) gateway.StatusMachine {
+	if operationType == connect.OperationPayout {
+		return gateway.PayoutStatusMachine
+	}
+	return gateway.PaymentStatusMachine
+}
+
+func isFinalRPStatus(status string) bool {
+	return status == "approved" || status == "declined"
+}
+
+// Business callback for the decision, nil if the business is not notified
+type notifyFunc func(decision statusDecision) *gatewayCallbackParams
+
+// Run provider status through the operation state machine, record the transition
+// and update the ledger. Regressions are recorded but never reach the business.
+// The business callback built by notify is enqueued in the same transaction, so a
+// status change is never committed without its callback.
+func (state *ApiState) applyStatus(ctx context.Context, update statusUpdate, notify notifyFunc) (statusDecision, error) {
+	var decision statusDecision
+	var enqueued bool
+	// Transitions of one operation are serialized by the transaction: SQLite takes the
+	// write lock on begin, PostgreSQL instances wait on the lock of the gateway id
+	if err := state.queries.InTx(ctx, func(queries *db.Store) error {
+		if err := queries.LockKey(ctx, "transition:"+update.gatewayID); err != nil {
+			return err
+		}
+
+		var err error
+		decision, err = decideStatus(ctx, queries, update)
+		if err != nil {
+			return err
+		}
+		if !decision.forward || notify == nil {
+			return nil
+		}
+		params := notify(decision)
+		if params == nil {
+			return nil
+		}
+		enqueued = true
+		return enqueueCallback(ctx, queries, *params)
+	}); err != nil {
+		// Nothing was recorded, the provider status will be seen again by a callback or the poller
+		return statusDecision{forward: false, rpStatus: "pending"}, err
+	}
+
+	if enqueued {
+		state.wakeCallbackWorker()
+	}
+	return decision, nil
+}
+
+func decideStatus(ctx context.Context, queries *db.Store, update statusUpdate) (statusDecision, error) {
+	var fromStatus, currentRPStatus string
+	transaction, err := queries.GetTransactionByGatewayID(ctx, nullString(update.gatewayID))
+	if err == nil {
+		fromStatus = transaction.ProviderStatus.String
+		currentRPStatus = transaction.RpStatus
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return statusDecision{}, fmt.Errorf("failed to load transaction: %w", err)
+	}
+
+	result := statusMachine(update.operationType).Check(fromStatus, update.providerStatus)
+
+	switch result {
+	case gateway.TransitionDuplicate:
+		if currentRPStatus == "" {
+			currentRPStatus = update.rpStatus
+		}
+		return statusDecision{forward: false, rpStatus: currentRPStatus}, nil
+	case gateway.TransitionRejected:
+		utils.Logger(ctx).Warn(
+			"Refusing status transition",
+			"from", fromStatus,
+			"to", update.providerStatus,
+			"source", update.source,
+		)
+		if err := recordTransition(ctx, queries, update, fromStatus, false); err != nil {
+			return statusDecision{}, err
+		}
+		if currentRPStatus == "" {
+			currentRPStatus = "pending"
+		}
+		return statusDecision{forward: false, rpStatus: currentRPStatus}, nil
+	}
+
+	if err := recordTransition(ctx, queries, update, fromStatus, true); err != nil {
+		return statusDecision{}, err
+	}
+
+	// Transaction is not in the ledger, nothing to compare against
+	if currentRPStatus == "" {
+		return statusDecision{forward: true, rpStatus: update.rpStatus}, nil
+	}
+
+	// Appeal of a finished operation puts it back to pending on the provider side,
+	// but the business only hears about the appeal outcome
+	rpStatus := update.rpStatus
+	if isFinalRPStatus(currentRPStatus) && !isFinalRPStatus(rpStatus) {
+		rpStatus = currentRPStatus
+	}
+
+	if err := recordStatus(ctx, queries, update.gatewayID, update.providerStatus, update.amount, rpStatus); err != nil {
+		return statusDecision{}, err
+	}
+	return statusDecision{forward: rpStatus != currentRPStatus, rpStatus: rpStatus}, nil
+}
+
+func recordTransition(ctx context.Context, queries *db.Store, update statusUpdate, fromStatus string, accepted bool) error {
+	if err := queries.CreateStatusTransition(ctx, db.CreateStatusTransitionParams{
+		GatewayID:  update.gatewayID,
+		FromStatus: nullString(fromStatus),
+		ToStatus:   update.providerStatus,
+		Source:     update.source,
+		Accepted:   accepted,
+		CreatedAt:  time.Now().UTC(),
+	}); err != nil {
+		return fmt.Errorf("failed to record status transition: %w", err)
+	}
+	return nil
+}
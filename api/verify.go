@@ -0,0 +1,176 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/dog4ik/stbl/config"
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/utils"
+)
+
+// Callbacks larger than this are rejected before verification
+const maxCallbackBodySize = 1 << 20
+
+// Part of a rejected callback body that is stored, the rest is only identified by size and digest
+const rejectedBodyPrefixSize = 1 << 10
+
+// Check that an inbound provider callback is authentic
+type callbackVerifier interface {
+	verify(r *http.Request, body []byte) error
+}
+
+// HMAC-SHA256 signature of the raw body, hex encoded, optionally prefixed with "sha256="
+type hmacVerifier struct {
+	header string
+	secret []byte
+}
+
+func (v hmacVerifier) verify(r *http.Request, body []byte) error {
+	signature := strings.TrimPrefix(r.Header.Get(v.header), "sha256=")
+	if signature == "" {
+		return fmt.Errorf("missing %s header", v.header)
+	}
+
+	provided, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed %s header", v.header)
+	}
+
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write(body)
+	if !hmac.Equal(provided, mac.Sum(nil)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// Shared secret that the provider puts into the callback url
+type pathTokenVerifier struct {
+	token string
+}
+
+func (v pathTokenVerifier) verify(r *http.Request, _ []byte) error {
+	provided := r.PathValue("secret")
+	if provided == "" {
+		return fmt.Errorf("missing path token")
+	}
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(v.token)) != 1 {
+		return fmt.Errorf("path token mismatch")
+	}
+	return nil
+}
+
+// Source address of the callback must belong to one of the allowed networks
+type ipAllowlistVerifier struct {
+	allowed []netip.Prefix
+}
+
+func (v ipAllowlistVerifier) verify(r *http.Request, _ []byte) error {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("unparsable remote address %s", r.RemoteAddr)
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range v.allowed {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	return fmt.Errorf("remote address %s is not allowed", addr)
+}
+
+type callbackVerification struct {
+	verifiers []callbackVerifier
+	// Body signature is checked, so the callback content can be trusted as is
+	signed bool
+	// Confirm unsigned callbacks by requesting the status from the provider
+	refetchStatus bool
+}
+
+func newCallbackVerification(cfg config.CallbackConfig) callbackVerification {
+	var verification callbackVerification
+
+	if cfg.HmacSecret != "" {
+		verification.verifiers = append(verification.verifiers, hmacVerifier{
+			header: cfg.SignatureHeader,
+			secret: []byte(cfg.HmacSecret),
+		})
+		verification.signed = true
+	}
+
+	if cfg.PathToken != "" {
+		verification.verifiers = append(verification.verifiers, pathTokenVerifier{token: string(cfg.PathToken)})
+	}
+
+	if len(cfg.IPAllowlist) != 0 {
+		verification.verifiers = append(verification.verifiers, ipAllowlistVerifier{allowed: cfg.IPAllowlist})
+	}
+
+	verification.refetchStatus = cfg.VerifyStatus
+
+	if len(verification.verifiers) == 0 && !verification.refetchStatus {
+		slog.Warn("Provider callbacks are accepted without verification")
+	}
+
+	return verification
+}
+
+// Read the callback body and run it through every configured verifier.
+// Rejected callbacks are recorded and answered with 401.
+func (state *ApiState) readVerifiedCallback(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBodySize))
+	if err != nil {
+		callbackError(w, r, "failed to read callback body", err)
+		return nil, false
+	}
+
+	for _, verifier := range state.callbackVerification.verifiers {
+		if err := verifier.verify(r, body); err != nil {
+			state.rejectCallback(w, r, body, err)
+			return nil, false
+		}
+	}
+
+	return body, true
+}
+
+func (state *ApiState) rejectCallback(w http.ResponseWriter, r *http.Request, body []byte, reason error) {
+	utils.Logger(r.Context()).Warn("Rejected unverified callback", "pattern", r.Pattern, "remote_addr", r.RemoteAddr, "reason", reason)
+
+	digest := sha256.Sum256(body)
+	prefix := body[:min(len(body), rejectedBodyPrefixSize)]
+
+	if err := state.queries.CreateCallbackRejection(r.Context(), db.CreateCallbackRejectionParams{
+		Path:       r.Pattern,
+		RemoteAddr: r.RemoteAddr,
+		Reason:     reason.Error(),
+		Body:       strings.ToValidUTF8(string(prefix), "\uFFFD"),
+		BodySize:   int64(len(body)),
+		BodySha256: hex.EncodeToString(digest[:]),
+	}); err != nil {
+		utils.Logger(r.Context()).Error("Failed to record callback rejection", "err", err)
+	}
+
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// Whether callback content has to be confirmed with the provider before it is forwarded
+func (state *ApiState) needsStatusRefetch() bool {
+	return state.callbackVerification.refetchStatus && !state.callbackVerification.signed
+}
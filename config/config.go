@@ -0,0 +1,394 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/gateway"
+	"github.com/joho/godotenv"
+)
+
+// Key size of AES-256, the sign key encrypts merchant keys in business callbacks
+const SIGN_KEY_SIZE = 32
+
+// Value that is never printed, neither by fmt nor by slog
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type Config struct {
+	Port            int
+	BusinessUrl     string
+	SignKey         Secret
+	AdminToken      Secret
+	ShutdownTimeout time.Duration
+
+	Log             LogConfig
+	Database        DatabaseConfig
+	Encryption      EncryptionConfig
+	Gateway         GatewayConfig
+	Callbacks       CallbackConfig
+	Poller          PollerConfig
+	Readiness       ReadinessConfig
+	InteractionLogs InteractionLogConfig
+}
+
+type LogConfig struct {
+	Level  string
+	Format string
+}
+
+type DatabaseConfig struct {
+	// PostgreSQL url, sqlite:// url or SQLite file path, may carry a password
+	Url         Secret
+	ReadConns   int
+	BusyTimeout time.Duration
+	AutoMigrate bool
+}
+
+type EncryptionConfig struct {
+	Keys  Secret
+	KeyID string
+	// Parsed from Keys, disabled when no keys are configured
+	Keyring *db.Keyring
+}
+
+type GatewayConfig struct {
+	ProdBaseUrl     string
+	SandboxBaseUrl  string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	Timeouts        gateway.Timeouts
+	Retry           gateway.RetryPolicy
+	Breaker         gateway.BreakerConfig
+	RateLimits      gateway.RateLimits
+}
+
+type CallbackConfig struct {
+	// Business callback delivery
+	MaxAttempts      int
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// Provider callback verification
+	HmacSecret      Secret
+	SignatureHeader string
+	PathToken       Secret
+	IPAllowlist     []netip.Prefix
+	VerifyStatus    bool
+}
+
+type PollerConfig struct {
+	Interval    time.Duration
+	MaxAge      time.Duration
+	Concurrency int
+}
+
+type ReadinessConfig struct {
+	ProbeProvider bool
+	Timeout       time.Duration
+}
+
+type InteractionLogConfig struct {
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+// Every problem found while loading the configuration
+type ValidationError struct {
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Problems
+}
+
+// Load the configuration from the process env, the .env file and the file CONFIG_FILE points to,
+// in that order of precedence. All invalid and missing values are reported together.
+func Load() (*Config, error) {
+	e := &env{}
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		e.errs = append(e.errs, fmt.Errorf(".env: %w", err))
+	}
+	if path, present := os.LookupEnv("CONFIG_FILE"); present {
+		file, err := godotenv.Read(path)
+		if err != nil {
+			e.fail("CONFIG_FILE", "%v", err)
+		}
+		e.file = file
+	}
+
+	config := &Config{
+		BusinessUrl:     e.url("BUSINESS_URL"),
+		SignKey:         Secret(e.required("SIGN_KEY")),
+		AdminToken:      Secret(e.string("ADMIN_TOKEN", "")),
+		ShutdownTimeout: e.duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		Log: LogConfig{
+			Level:  e.string("LOG_LEVEL", "info"),
+			Format: strings.ToLower(e.string("LOG_FORMAT", "text")),
+		},
+		Database: DatabaseConfig{
+			ReadConns:   e.intAtLeast("SQLITE_READ_CONNS", 4, 1),
+			BusyTimeout: e.duration("SQLITE_BUSY_TIMEOUT", 5*time.Second),
+			AutoMigrate: e.bool("AUTO_MIGRATE", true),
+		},
+		Encryption: EncryptionConfig{
+			Keys:  Secret(e.string("ENCRYPTION_KEYS", "")),
+			KeyID: e.string("ENCRYPTION_KEY_ID", ""),
+		},
+		Gateway: GatewayConfig{
+			ProdBaseUrl:     e.url("BASE_URL"),
+			SandboxBaseUrl:  e.url("SANDBOX_BASE_URL"),
+			AccessTokenTTL:  e.duration("ACCESS_TOKEN_TTL", gateway.ACCESS_TOKEN_TTL),
+			RefreshTokenTTL: e.duration("REFRESH_TOKEN_TTL", gateway.REFRESH_TOKEN_TTL),
+			Timeouts: gateway.Timeouts{
+				Login:  e.duration("GATEWAY_LOGIN_TIMEOUT", gateway.LOGIN_TIMEOUT),
+				Create: e.duration("GATEWAY_CREATE_TIMEOUT", gateway.CREATE_TIMEOUT),
+				Status: e.duration("GATEWAY_STATUS_TIMEOUT", gateway.STATUS_TIMEOUT),
+			},
+			Retry: gateway.RetryPolicy{
+				MaxAttempts:       e.intAtLeast("GATEWAY_RETRY_MAX_ATTEMPTS", gateway.RETRY_MAX_ATTEMPTS, 1),
+				Backoff:           e.duration("GATEWAY_RETRY_BACKOFF", gateway.RETRY_BACKOFF),
+				MaxBackoff:        e.duration("GATEWAY_RETRY_MAX_BACKOFF", gateway.RETRY_MAX_BACKOFF),
+				RetryableStatuses: e.intList("GATEWAY_RETRY_STATUSES", gateway.RETRYABLE_STATUSES),
+			},
+			Breaker: gateway.BreakerConfig{
+				Window:           e.intAtLeast("CIRCUIT_WINDOW", gateway.CIRCUIT_WINDOW, 1),
+				MinRequests:      e.intAtLeast("CIRCUIT_MIN_REQUESTS", gateway.CIRCUIT_MIN_REQUESTS, 1),
+				FailureRate:      e.float("CIRCUIT_FAILURE_RATE", gateway.CIRCUIT_FAILURE_RATE),
+				OpenDuration:     e.duration("CIRCUIT_OPEN_DURATION", gateway.CIRCUIT_OPEN_DURATION),
+				HalfOpenRequests: e.intAtLeast("CIRCUIT_HALF_OPEN_REQUESTS", gateway.CIRCUIT_HALF_OPEN_REQUESTS, 1),
+			},
+			RateLimits: gateway.RateLimits{
+				Login: gateway.RateLimit{
+					Rate:  e.float("RATE_LIMIT_LOGIN_RPS", 0),
+					Burst: e.intAtLeast("RATE_LIMIT_LOGIN_BURST", gateway.RATE_LIMIT_BURST, 1),
+				},
+				Create: gateway.RateLimit{
+					Rate:  e.float("RATE_LIMIT_CREATE_RPS", 0),
+					Burst: e.intAtLeast("RATE_LIMIT_CREATE_BURST", gateway.RATE_LIMIT_BURST, 1),
+				},
+				Status: gateway.RateLimit{
+					Rate:  e.float("RATE_LIMIT_STATUS_RPS", 0),
+					Burst: e.intAtLeast("RATE_LIMIT_STATUS_BURST", gateway.RATE_LIMIT_BURST, 1),
+				},
+				MaxWait: e.duration("RATE_LIMIT_MAX_WAIT", gateway.RATE_LIMIT_MAX_WAIT),
+			},
+		},
+		Callbacks: CallbackConfig{
+			MaxAttempts:      e.intAtLeast("CALLBACK_MAX_ATTEMPTS", 10, 1),
+			RetryInterval:    e.duration("CALLBACK_RETRY_INTERVAL", 10*time.Second),
+			MaxRetryInterval: e.duration("CALLBACK_MAX_RETRY_INTERVAL", time.Hour),
+			HmacSecret:       Secret(e.string("CALLBACK_HMAC_SECRET", "")),
+			SignatureHeader:  e.string("CALLBACK_SIGNATURE_HEADER", "X-Signature"),
+			PathToken:        Secret(e.string("CALLBACK_PATH_TOKEN", "")),
+			IPAllowlist:      e.allowlist("CALLBACK_IP_ALLOWLIST"),
+			VerifyStatus:     e.bool("CALLBACK_VERIFY_STATUS", false),
+		},
+		Poller: PollerConfig{
+			Interval:    e.duration("STATUS_POLL_INTERVAL", time.Minute),
+			MaxAge:      e.duration("STATUS_POLL_MAX_AGE", 72*time.Hour),
+			Concurrency: e.intAtLeast("STATUS_POLL_CONCURRENCY", 4, 1),
+		},
+		Readiness: ReadinessConfig{
+			ProbeProvider: e.bool("READINESS_PROBE_PROVIDER", false),
+			Timeout:       e.duration("READINESS_TIMEOUT", 2*time.Second),
+		},
+		InteractionLogs: InteractionLogConfig{
+			Retention:     e.duration("INTERACTION_LOG_RETENTION", 90*24*time.Hour),
+			PurgeInterval: e.duration("INTERACTION_LOG_PURGE_INTERVAL", time.Hour),
+		},
+	}
+
+	// DATABASE_PATH predates PostgreSQL support and always points to a SQLite file
+	config.Database.Url = Secret(e.string("DATABASE_URL", ""))
+	if config.Database.Url == "" {
+		config.Database.Url = Secret(e.required("DATABASE_PATH"))
+	}
+
+	config.validate(e)
+
+	if len(e.errs) != 0 {
+		return nil, &ValidationError{Problems: e.errs}
+	}
+	return config, nil
+}
+
+// Checks that span more than parsing a single value
+func (config *Config) validate(e *env) {
+	if port := e.required("PORT"); port != "" {
+		number, err := strconv.Atoi(port)
+		if err != nil {
+			e.fail("PORT", "%q is not a number", port)
+		} else if number < 1 || number > 65535 {
+			e.fail("PORT", "must be between 1 and 65535, got %d", number)
+		}
+		config.Port = number
+	}
+
+	if size := len(config.SignKey); size != 0 && size != SIGN_KEY_SIZE {
+		e.fail("SIGN_KEY", "must be %d bytes long for AES-256, got %d", SIGN_KEY_SIZE, size)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Log.Level)); err != nil {
+		e.fail("LOG_LEVEL", "unknown log level %q", config.Log.Level)
+	}
+	if config.Log.Format != "text" && config.Log.Format != "json" {
+		e.fail("LOG_FORMAT", "unknown log format %q", config.Log.Format)
+	}
+
+	if rate := config.Gateway.Breaker.FailureRate; rate <= 0 || rate > 1 {
+		e.fail("CIRCUIT_FAILURE_RATE", "must be above 0 and at most 1, got %v", rate)
+	}
+	limits := config.Gateway.RateLimits
+	for _, limit := range []struct {
+		key  string
+		rate float64
+	}{
+		{"RATE_LIMIT_LOGIN_RPS", limits.Login.Rate},
+		{"RATE_LIMIT_CREATE_RPS", limits.Create.Rate},
+		{"RATE_LIMIT_STATUS_RPS", limits.Status.Rate},
+	} {
+		if limit.rate < 0 {
+			e.fail(limit.key, "must not be negative, got %v", limit.rate)
+		}
+	}
+
+	keyring, err := db.ParseKeyring(string(config.Encryption.Keys), config.Encryption.KeyID)
+	if err != nil {
+		e.fail("ENCRYPTION_KEYS", "%v", err)
+	}
+	config.Encryption.Keyring = keyring
+}
+
+// Comma separated list of addresses and CIDR networks
+func (e *env) allowlist(key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for entry := range strings.SplitSeq(e.string(key, ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				e.fail(key, "invalid network %q: %v", entry, err)
+				continue
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			e.fail(key, "invalid address %q: %v", entry, err)
+			continue
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes
+}
+
+// Printable configuration, secrets are redacted and database passwords are hidden
+func (config *Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("port", config.Port),
+		slog.String("business_url", config.BusinessUrl),
+		slog.Any("sign_key", config.SignKey),
+		slog.Any("admin_token", config.AdminToken),
+		slog.Duration("shutdown_timeout", config.ShutdownTimeout),
+		slog.Group("log",
+			slog.String("level", config.Log.Level),
+			slog.String("format", config.Log.Format),
+		),
+		slog.Group("database",
+			slog.String("url", redactDatabaseUrl(string(config.Database.Url))),
+			slog.Int("read_conns", config.Database.ReadConns),
+			slog.Duration("busy_timeout", config.Database.BusyTimeout),
+			slog.Bool("auto_migrate", config.Database.AutoMigrate),
+		),
+		slog.Group("encryption",
+			slog.Any("keys", config.Encryption.Keys),
+			slog.String("key_id", config.Encryption.KeyID),
+		),
+		slog.Group("gateway",
+			slog.String("base_url", config.Gateway.ProdBaseUrl),
+			slog.String("sandbox_base_url", config.Gateway.SandboxBaseUrl),
+			slog.Duration("access_token_ttl", config.Gateway.AccessTokenTTL),
+			slog.Duration("refresh_token_ttl", config.Gateway.RefreshTokenTTL),
+			slog.Any("timeouts", config.Gateway.Timeouts),
+			slog.Any("retry", config.Gateway.Retry),
+			slog.Any("breaker", config.Gateway.Breaker),
+			slog.Any("rate_limits", config.Gateway.RateLimits),
+		),
+		slog.Group("callbacks",
+			slog.Int("max_attempts", config.Callbacks.MaxAttempts),
+			slog.Duration("retry_interval", config.Callbacks.RetryInterval),
+			slog.Duration("max_retry_interval", config.Callbacks.MaxRetryInterval),
+			slog.Any("hmac_secret", config.Callbacks.HmacSecret),
+			slog.String("signature_header", config.Callbacks.SignatureHeader),
+			slog.Any("path_token", config.Callbacks.PathToken),
+			slog.Any("ip_allowlist", config.Callbacks.IPAllowlist),
+			slog.Bool("verify_status", config.Callbacks.VerifyStatus),
+		),
+		slog.Group("poller",
+			slog.Duration("interval", config.Poller.Interval),
+			slog.Duration("max_age", config.Poller.MaxAge),
+			slog.Int("concurrency", config.Poller.Concurrency),
+		),
+		slog.Group("readiness",
+			slog.Bool("probe_provider", config.Readiness.ProbeProvider),
+			slog.Duration("timeout", config.Readiness.Timeout),
+		),
+		slog.Group("interaction_logs",
+			slog.Duration("retention", config.InteractionLogs.Retention),
+			slog.Duration("purge_interval", config.InteractionLogs.PurgeInterval),
+		),
+	)
+}
+
+// SQLite paths are printed as is, PostgreSQL urls without the password
+func redactDatabaseUrl(databaseUrl string) string {
+	parsed, err := url.Parse(databaseUrl)
+	if err != nil {
+		// The password can not be located, so nothing is printed
+		return Secret(databaseUrl).String()
+	}
+	if parsed.User != nil {
+		return parsed.Redacted()
+	}
+	return databaseUrl
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reads values from the process env with the config file as a fallback.
+// Parse errors are collected, so every problem is reported at once.
+type env struct {
+	file map[string]string
+	errs []error
+}
+
+func (e *env) lookup(key string) (string, bool) {
+	if value, present := os.LookupEnv(key); present {
+		return value, true
+	}
+	value, present := e.file[key]
+	return value, present
+}
+
+func (e *env) fail(key string, format string, args ...any) {
+	e.errs = append(e.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+// Value that has no sensible default
+func (e *env) required(key string) string {
+	value, present := e.lookup(key)
+	if !present || value == "" {
+		e.fail(key, "is required")
+	}
+	return value
+}
+
+func (e *env) string(key string, fallback string) string {
+	if value, present := e.lookup(key); present {
+		return value
+	}
+	return fallback
+}
+
+func (e *env) int(key string, fallback int) int {
+	value, present := e.lookup(key)
+	if !present {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		e.fail(key, "%q is not a number", value)
+		return fallback
+	}
+	return number
+}
+
+// Integer that must be at least min
+func (e *env) intAtLeast(key string, fallback int, min int) int {
+	number := e.int(key, fallback)
+	if number < min {
+		e.fail(key, "must be at least %d, got %d", min, number)
+	}
+	return number
+}
+
+func (e *env) float(key string, fallback float64) float64 {
+	value, present := e.lookup(key)
+	if !present {
+		return fallback
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.fail(key, "%q is not a number", value)
+		return fallback
+	}
+	return number
+}
+
+// Duration like "30s" or "5m", negative durations are rejected
+func (e *env) duration(key string, fallback time.Duration) time.Duration {
+	value, present := e.lookup(key)
+	if !present {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		e.fail(key, "%q is not a valid duration", value)
+		return fallback
+	}
+	if duration < 0 {
+		e.fail(key, "must not be negative, got %s", duration)
+	}
+	return duration
+}
+
+func (e *env) bool(key string, fallback bool) bool {
+	value, present := e.lookup(key)
+	if !present {
+		return fallback
+	}
+
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		e.fail(key, "%q is not true or false", value)
+		return fallback
+	}
+	return flag
+}
+
+// Comma separated integers
+func (e *env) intList(key string, fallback []int) []int {
+	value, present := e.lookup(key)
+	if !present {
+		return fallback
+	}
+
+	var numbers []int
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		number, err := strconv.Atoi(entry)
+		if err != nil {
+			e.fail(key, "%q is not a number", entry)
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+// Required absolute http(s) url
+func (e *env) url(key string) string {
+	value := e.required(key)
+	if value == "" {
+		return value
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil {
+		e.fail(key, "%q is not a valid url: %v", value, err)
+		return value
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		e.fail(key, "%q must be an absolute http or https url", value)
+	}
+	return value
+}
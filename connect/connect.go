@@ -1,7 +1,9 @@
 package connect
 
 type GwConnectError struct {
-	Result bool             `json:"result"`
-	Error  string           `json:"error"`
-	Logs   []InteractionLog `json:"logs"`
+	Result bool   `json:"result"`
+	Error  string `json:"error"`
+	// Failure cause of a provider call, see gateway.ErrorKind
+	Kind string           `json:"kind,omitempty"`
+	Logs []InteractionLog `json:"logs"`
 }
@@ -17,23 +17,30 @@ type InteractionLog struct {
 	Kind      string    `json:"kind"`
 	CreatedAt time.Time `json:"created_at"` // RFC3339 by default in Go
 	Duration  float64   `json:"duration"`
+	RequestID string    `json:"request_id,omitempty"`
 }
 
 type LogWriter struct {
 	created        time.Time
 	kind           string
+	requestID      string
 	responseStatus *int
 	request        *Request
 	response       *string
 }
 
-func newLogWriter(kind string) LogWriter {
+func newLogWriter(kind string, requestID string) LogWriter {
 	return LogWriter{
-		kind:    kind,
-		created: time.Now(),
+		kind:      kind,
+		requestID: requestID,
+		created:   time.Now(),
 	}
 }
 
+func (self *LogWriter) Kind() string {
+	return self.kind
+}
+
 func (self *LogWriter) SetStatus(status int) {
 	self.responseStatus = &status
 }
@@ -55,21 +62,33 @@ func (self LogWriter) IntoInteractionLog() InteractionLog {
 		Kind:      self.kind,
 		CreatedAt: self.created,
 		Duration:  time.Since(self.created).Seconds(),
+		RequestID: self.requestID,
 	}
 }
 
 type InteractionLogs struct {
-	logs    []InteractionLog
-	Current *LogWriter
+	logs      []InteractionLog
+	requestID string
+	Current   *LogWriter
 }
 
 func EmptyInteractionLogs() InteractionLogs {
+	return NewInteractionLogs("")
+}
+
+// Interaction logs tagged with the correlation id of the request they belong to
+func NewInteractionLogs(requestID string) InteractionLogs {
 	return InteractionLogs{
-		logs:    []InteractionLog{},
-		Current: nil,
+		logs:      []InteractionLog{},
+		requestID: requestID,
+		Current:   nil,
 	}
 }
 
+func (self *InteractionLogs) RequestID() string {
+	return self.requestID
+}
+
 func (self *InteractionLogs) AddLog(log LogWriter) {
 	self.logs = append(self.logs, log.IntoInteractionLog())
 }
@@ -79,11 +98,20 @@ func (self *InteractionLogs) Enter(kind string) *LogWriter {
 	if self.Current != nil {
 		self.logs = append(self.logs, self.Current.IntoInteractionLog())
 	}
-	newWriter := newLogWriter(kind)
+	newWriter := newLogWriter(kind, self.requestID)
 	self.Current = &newWriter
 	return &newWriter
 }
 
+// Close the current span, logs returned by IntoInner afterwards stay the same
+func (self *InteractionLogs) Finish() []InteractionLog {
+	if self.Current != nil {
+		self.logs = append(self.logs, self.Current.IntoInteractionLog())
+		self.Current = nil
+	}
+	return self.logs
+}
+
 // This method should be called once
 func (self InteractionLogs) IntoInner() []InteractionLog {
 	if self.Current != nil {
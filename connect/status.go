@@ -1,5 +1,10 @@
 package connect
 
+const (
+	OperationPay    = "pay"
+	OperationPayout = "payout"
+)
+
 type StatusRequest struct {
 	Payment  StatusPayment `json:"payment"`
 	Settings Settings      `json:"settings"`
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Operations in the benchmark database
+const benchMappings = 1000
+
+// Connection as it was opened before the read/write split
+func openLegacy(path string) (*Conn, error) {
+	conn, err := sql.Open("sqlite", fmt.Sprintf("%s?cache=shared", path))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(1)
+	return NewConn(conn, conn, DialectSQLite), nil
+}
+
+func benchGatewayID(i int) string {
+	return fmt.Sprintf("bench-%d", i)
+}
+
+func seedBench(ctx context.Context, conn *Conn, queries *Store) error {
+	if _, err := Migrate(ctx, conn.DB, conn.Dialect); err != nil {
+		return err
+	}
+
+	return queries.InTx(ctx, func(queries *Store) error {
+		now := time.Now().UTC()
+		for i := range benchMappings {
+			token := fmt.Sprintf("token-%d", i)
+			if _, err := queries.CreateMapping(ctx, CreateMappingParams{GatewayID: benchGatewayID(i), Token: token, MerchantPrivateKey: "key"}); err != nil {
+				return err
+			}
+			if err := queries.CreateGatewaySettings(ctx, CreateGatewaySettingsParams{GatewayID: benchGatewayID(i), Login: "login", Password: "password"}); err != nil {
+				return err
+			}
+			if _, err := queries.CreateTransaction(ctx, CreateTransactionParams{
+				Token:         token,
+				OperationType: "pay",
+				Amount:        1000,
+				RpStatus:      "pending",
+				ExternalID:    token,
+				Now:           now,
+			}); err != nil {
+				return err
+			}
+			if err := queries.SetTransactionGateway(ctx, SetTransactionGatewayParams{
+				GatewayID: sql.NullString{String: benchGatewayID(i), Valid: true},
+				RpStatus:  "pending",
+				UpdatedAt: now,
+				Token:     token,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Provider callback as the api applies it: the mapping and settings reads, then the
+// transition, ledger update and outbox entry in one transaction under the gateway id lock
+func benchCallback(ctx context.Context, queries *Store, i int) (time.Duration, error) {
+	started := time.Now()
+	mapping, err := queries.GetMapping(ctx, benchGatewayID(i))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := queries.GetGatewaySettings(ctx, benchGatewayID(i)); err != nil {
+		return 0, err
+	}
+	readLatency := time.Since(started)
+
+	return readLatency, queries.InTx(ctx, func(queries *Store) error {
+		if err := queries.LockKey(ctx, "transition:"+benchGatewayID(i)); err != nil {
+			return err
+		}
+
+		id := sql.NullString{String: benchGatewayID(i), Valid: true}
+		transaction, err := queries.GetTransactionByGatewayID(ctx, id)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if err := queries.CreateStatusTransition(ctx, CreateStatusTransitionParams{
+			GatewayID:  benchGatewayID(i),
+			FromStatus: transaction.ProviderStatus,
+			ToStatus:   "COMPLETED",
+			Source:     "callback",
+			Accepted:   true,
+			CreatedAt:  now,
+		}); err != nil {
+			return err
+		}
+		if err := queries.UpdateTransactionStatus(ctx, UpdateTransactionStatusParams{
+			ProviderStatus: sql.NullString{String: "COMPLETED", Valid: true},
+			RpStatus:       "approved",
+			UpdatedAt:      now,
+			GatewayID:      id,
+		}); err != nil {
+			return err
+		}
+		_, err = queries.EnqueueCallback(ctx, EnqueueCallbackParams{
+			GatewayID:     benchGatewayID(i),
+			Token:         mapping.Token,
+			Payload:       `{"status":"approved","currency":"ARS","amount":1000}`,
+			NextAttemptAt: now,
+		})
+		return err
+	})
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(int(float64(len(sorted))*p), len(sorted)-1)]
+}
+
+// Callback throughput while token refreshes keep writing to token_cache, on the legacy
+// single shared-cache connection and on WAL with a read pool next to a single writer.
+//
+//	go test ./db -run '^$' -bench Callbacks -cpu 32
+func BenchmarkCallbacks(b *testing.B) {
+	setups := []struct {
+		name string
+		open func(path string) (*Conn, error)
+	}{
+		{"shared_cache", openLegacy},
+		{"wal_read_pool", func(path string) (*Conn, error) {
+			return Open(path, ConnConfig{ReadConns: 4, BusyTimeout: 5 * time.Second})
+		}},
+	}
+
+	for _, setup := range setups {
+		b.Run(setup.name, func(b *testing.B) {
+			ctx := context.Background()
+			conn, err := setup.open(filepath.Join(b.TempDir(), "bench.sqlite"))
+			if err != nil {
+				b.Fatalf("failed to open database: %v", err)
+			}
+			defer conn.Close()
+
+			queries := NewStore(conn, nil)
+			if err := seedBench(ctx, conn, queries); err != nil {
+				b.Fatalf("failed to seed: %v", err)
+			}
+
+			// Token refreshes of a few merchants compete for the writer
+			refreshCtx, stopRefreshes := context.WithCancel(ctx)
+			var refreshes atomic.Int64
+			var refreshers sync.WaitGroup
+			refreshers.Go(func() {
+				for i := 0; refreshCtx.Err() == nil; i++ {
+					now := time.Now().UTC()
+					if err := queries.UpsertTokenCache(refreshCtx, UpsertTokenCacheParams{
+						CredentialsHash:    fmt.Sprintf("merchant-%d", i%10),
+						AccessToken:        "access",
+						RefreshToken:       "refresh",
+						AccessRefreshedAt:  now,
+						RefreshRefreshedAt: now,
+					}); err == nil {
+						refreshes.Add(1)
+					}
+				}
+			})
+
+			var next atomic.Int64
+			var mu sync.Mutex
+			var latencies []time.Duration
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var local []time.Duration
+				for pb.Next() {
+					i := int(next.Add(1)) % benchMappings
+					latency, err := benchCallback(ctx, queries, i)
+					if err != nil {
+						b.Errorf("callback failed: %v", err)
+						return
+					}
+					local = append(local, latency)
+				}
+				mu.Lock()
+				latencies = append(latencies, local...)
+				mu.Unlock()
+			})
+			b.StopTimer()
+
+			stopRefreshes()
+			refreshers.Wait()
+
+			slices.Sort(latencies)
+			seconds := b.Elapsed().Seconds()
+			b.ReportMetric(float64(b.N)/seconds, "callbacks/s")
+			b.ReportMetric(float64(refreshes.Load())/seconds, "refreshes/s")
+			b.ReportMetric(float64(percentile(latencies, 0.5).Microseconds()), "read-p50-us")
+			b.ReportMetric(float64(percentile(latencies, 0.99).Microseconds()), "read-p99-us")
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Envelope encrypted values look like enc:v1:<key id>:<wrapped data key>:<nonce and ciphertext>.
+// Values without the prefix are plaintext written before encryption was enabled.
+const encryptedPrefix = "enc:v1:"
+
+const dataKeySize = 32
+
+var ErrUnknownKey = errors.New("value is encrypted with an unknown key")
+
+// Master keys by id, new values are encrypted with the active one
+type Keyring struct {
+	keys     map[string]cipher.AEAD
+	activeID string
+}
+
+// Parse comma separated <key id>:<base64 encoded 32 byte key> pairs
+func ParseKeyring(spec string, activeID string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string]cipher.AEAD), activeID: activeID}
+
+	for entry := range strings.SplitSeq(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("expected <key id>:<base64 key>, got %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s is not valid base64: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes, got %d", id, len(key))
+		}
+		if _, ok := keyring.keys[id]; ok {
+			return nil, fmt.Errorf("key %s is defined twice", id)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		keyring.keys[id] = aead
+	}
+
+	if len(keyring.keys) == 0 {
+		if activeID != "" {
+			return nil, fmt.Errorf("active key %s is set without any keys", activeID)
+		}
+		return keyring, nil
+	}
+	if _, ok := keyring.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active key %q is not in the keyring", activeID)
+	}
+
+	return keyring, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Whether new values are encrypted
+func (keyring *Keyring) Enabled() bool {
+	return keyring != nil && keyring.activeID != ""
+}
+
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, additionalData)
+}
+
+func open(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// Encrypt the value of the column with a fresh data key wrapped by the active master key.
+// The column name is authenticated so a value can not be moved to another column.
+func (keyring *Keyring) Encrypt(column string, plaintext string) (string, error) {
+	if !keyring.Enabled() {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	rand.Read(dataKey)
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey := seal(keyring.keys[keyring.activeID], dataKey, []byte(keyring.activeID))
+	ciphertext := seal(dataAEAD, []byte(plaintext), []byte(column))
+
+	return encryptedPrefix + keyring.activeID +
+		":" + base64.RawStdEncoding.EncodeToString(wrappedKey) +
+		":" + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt the value of the column, plaintext values are returned as is
+func (keyring *Keyring) Decrypt(column string, value string) (string, error) {
+	encrypted, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+
+	parts := strings.Split(encrypted, ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted %s", column)
+	}
+	keyID := parts[0]
+
+	var masterKey cipher.AEAD
+	if keyring != nil {
+		masterKey = keyring.keys[keyID]
+	}
+	if masterKey == nil {
+		return "", fmt.Errorf("%s: %w %s", column, ErrUnknownKey, keyID)
+	}
+
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed data key of %s: %w", column, err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext of %s: %w", column, err)
+	}
+
+	dataKey, err := open(masterKey, wrappedKey, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key of %s: %w", column, err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext, []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", column, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// Whether the value is plaintext or encrypted with a key other than the active one
+func (keyring *Keyring) needsRotation(value string) bool {
+	if !keyring.Enabled() {
+		return false
+	}
+	encrypted, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return true
+	}
+	keyID, _, _ := strings.Cut(encrypted, ":")
+	return keyID != keyring.activeID
+}
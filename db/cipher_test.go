@@ -0,0 +1,321 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(fill byte) string {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = fill
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func testKeyring(t *testing.T, spec string, activeID string) *Keyring {
+	t.Helper()
+	keyring, err := ParseKeyring(spec, activeID)
+	if err != nil {
+		t.Fatalf("ParseKeyring(%q, %q): %v", spec, activeID, err)
+	}
+	return keyring
+}
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		activeID string
+		wantErr  string
+		enabled  bool
+	}{
+		{name: "empty", spec: "", activeID: ""},
+		{name: "single key", spec: "k1:" + testKey(1), activeID: "k1", enabled: true},
+		{name: "spaces and trailing comma", spec: " k1:" + testKey(1) + " , k2:" + testKey(2) + ",", activeID: "k2", enabled: true},
+		{name: "missing separator", spec: testKey(1), activeID: "k1", wantErr: "expected <key id>:<base64 key>"},
+		{name: "empty id", spec: ":" + testKey(1), activeID: "k1", wantErr: "expected <key id>:<base64 key>"},
+		{name: "invalid base64", spec: "k1:not base64", activeID: "k1", wantErr: "not valid base64"},
+		{name: "short key", spec: "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), activeID: "k1", wantErr: "must be 32 bytes"},
+		{name: "duplicate id", spec: "k1:" + testKey(1) + ",k1:" + testKey(2), activeID: "k1", wantErr: "defined twice"},
+		{name: "active key missing", spec: "k1:" + testKey(1), activeID: "k2", wantErr: "not in the keyring"},
+		{name: "active key without keys", spec: "", activeID: "k1", wantErr: "without any keys"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyring, err := ParseKeyring(test.spec, test.activeID)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if keyring.Enabled() != test.enabled {
+				t.Errorf("Enabled() = %v, want %v", keyring.Enabled(), test.enabled)
+			}
+		})
+	}
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keyring := testKeyring(t, "k1:"+testKey(1), "k1")
+
+	encrypted, err := keyring.Encrypt(columnMerchantPrivateKey, "merchant secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, encryptedPrefix+"k1:") {
+		t.Fatalf("Encrypt() = %q, want an envelope under k1", encrypted)
+	}
+	if strings.Contains(encrypted, "merchant secret") {
+		t.Fatal("envelope contains the plaintext")
+	}
+
+	again, err := keyring.Encrypt(columnMerchantPrivateKey, "merchant secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Error("same plaintext encrypted twice gives the same envelope")
+	}
+
+	decrypted, err := keyring.Decrypt(columnMerchantPrivateKey, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != "merchant secret" {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, "merchant secret")
+	}
+	if keyring.needsRotation(encrypted) {
+		t.Error("value under the active key needs rotation")
+	}
+}
+
+func TestKeyringPlaintextPassthrough(t *testing.T) {
+	for name, keyring := range map[string]*Keyring{
+		"nil":      nil,
+		"disabled": testKeyring(t, "", ""),
+	} {
+		t.Run(name, func(t *testing.T) {
+			encrypted, err := keyring.Encrypt(columnGatewayPassword, "password")
+			if err != nil || encrypted != "password" {
+				t.Fatalf("Encrypt() = %q, %v, want the plaintext", encrypted, err)
+			}
+			decrypted, err := keyring.Decrypt(columnGatewayPassword, "password")
+			if err != nil || decrypted != "password" {
+				t.Fatalf("Decrypt() = %q, %v, want the plaintext", decrypted, err)
+			}
+			if keyring.needsRotation("password") {
+				t.Error("plaintext needs rotation without an active key")
+			}
+		})
+	}
+
+	// Values written before encryption was enabled are still read, and rotated
+	keyring := testKeyring(t, "k1:"+testKey(1), "k1")
+	decrypted, err := keyring.Decrypt(columnGatewayPassword, "password")
+	if err != nil || decrypted != "password" {
+		t.Fatalf("Decrypt() = %q, %v, want the plaintext", decrypted, err)
+	}
+	if !keyring.needsRotation("password") {
+		t.Error("plaintext does not need rotation with an active key")
+	}
+}
+
+func TestKeyringRetiredKey(t *testing.T) {
+	old := testKeyring(t, "k1:"+testKey(1), "k1")
+	encrypted, err := old.Encrypt(columnAccessToken, "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := testKeyring(t, "k1:"+testKey(1)+",k2:"+testKey(2), "k2")
+	if !keyring.needsRotation(encrypted) {
+		t.Fatal("value under the retired key does not need rotation")
+	}
+
+	decrypted, err := keyring.Decrypt(columnAccessToken, encrypted)
+	if err != nil || decrypted != "access" {
+		t.Fatalf("Decrypt() = %q, %v, want %q", decrypted, err, "access")
+	}
+
+	rotated, err := keyring.Encrypt(columnAccessToken, decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rotated, encryptedPrefix+"k2:") {
+		t.Fatalf("rotated value %q is not under the active key", rotated)
+	}
+	if keyring.needsRotation(rotated) {
+		t.Error("rotated value needs rotation")
+	}
+
+	// Retired key can be dropped once everything is rotated
+	current := testKeyring(t, "k2:"+testKey(2), "k2")
+	if decrypted, err := current.Decrypt(columnAccessToken, rotated); err != nil || decrypted != "access" {
+		t.Fatalf("Decrypt() = %q, %v, want %q", decrypted, err, "access")
+	}
+}
+
+func TestKeyringColumnIsAuthenticated(t *testing.T) {
+	keyring := testKeyring(t, "k1:"+testKey(1), "k1")
+	encrypted, err := keyring.Encrypt(columnAccessToken, "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keyring.Decrypt(columnRefreshToken, encrypted); err == nil {
+		t.Fatal("value moved to another column was decrypted")
+	}
+}
+
+func TestKeyringUnknownKey(t *testing.T) {
+	other := testKeyring(t, "k9:"+testKey(9), "k9")
+	encrypted, err := other.Encrypt(columnGatewayPassword, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, keyring := range map[string]*Keyring{
+		"nil":         nil,
+		"disabled":    testKeyring(t, "", ""),
+		"another key": testKeyring(t, "k1:"+testKey(1), "k1"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := keyring.Decrypt(columnGatewayPassword, encrypted); !errors.Is(err, ErrUnknownKey) {
+				t.Fatalf("got error %v, want ErrUnknownKey", err)
+			}
+		})
+	}
+
+	// Same id with different key material fails to unwrap the data key
+	impostor := testKeyring(t, "k9:"+testKey(8), "k9")
+	if _, err := impostor.Decrypt(columnGatewayPassword, encrypted); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("got error %v, want a data key unwrap failure", err)
+	}
+}
+
+func TestKeyringMalformedEnvelope(t *testing.T) {
+	keyring := testKeyring(t, "k1:"+testKey(1), "k1")
+	encrypted, err := keyring.Encrypt(columnMerchantPrivateKey, "merchant secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(encrypted, encryptedPrefix), ":")
+	wrappedKey, ciphertext := parts[1], parts[2]
+
+	sealed, err := base64.RawStdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	tampered := base64.RawStdEncoding.EncodeToString(sealed)
+
+	tests := map[string]string{
+		"missing parts":         encryptedPrefix + "k1:" + wrappedKey,
+		"extra part":            encrypted + ":extra",
+		"data key not base64":   encryptedPrefix + "k1:!!!:" + ciphertext,
+		"ciphertext not base64": encryptedPrefix + "k1:" + wrappedKey + ":!!!",
+		"data key too short":    encryptedPrefix + "k1:AAAA:" + ciphertext,
+		"ciphertext too short":  encryptedPrefix + "k1:" + wrappedKey + ":AAAA",
+		"tampered ciphertext":   encryptedPrefix + "k1:" + wrappedKey + ":" + tampered,
+		"empty":                 encryptedPrefix,
+	}
+
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			if decrypted, err := keyring.Decrypt(columnMerchantPrivateKey, value); err == nil {
+				t.Fatalf("Decrypt(%q) = %q, want an error", value, decrypted)
+			}
+		})
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	ctx := context.Background()
+	conn, err := Open(filepath.Join(t.TempDir(), "rotate.sqlite"), ConnConfig{ReadConns: 1, BusyTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := Migrate(ctx, conn.DB, conn.Dialect); err != nil {
+		t.Fatal(err)
+	}
+
+	// One mapping written before encryption was enabled, one under the retired key
+	plain := NewStore(conn, testKeyring(t, "", ""))
+	if _, err := plain.CreateMapping(ctx, CreateMappingParams{GatewayID: "g1", Token: "t1", MerchantPrivateKey: "plain key"}); err != nil {
+		t.Fatal(err)
+	}
+	retired := NewStore(conn, testKeyring(t, "k1:"+testKey(1), "k1"))
+	if _, err := retired.CreateMapping(ctx, CreateMappingParams{GatewayID: "g2", Token: "t2", MerchantPrivateKey: "old key"}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	if err := retired.UpsertTokenCache(ctx, UpsertTokenCacheParams{
+		CredentialsHash:    "merchant",
+		AccessToken:        "access",
+		RefreshToken:       "refresh",
+		AccessRefreshedAt:  now,
+		RefreshRefreshedAt: now,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := retired.CreateGatewaySettings(ctx, CreateGatewaySettingsParams{GatewayID: "g2", Login: "login", Password: "password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewStore(conn, testKeyring(t, "k1:"+testKey(1)+",k2:"+testKey(2), "k2"))
+	stats, err := store.RotateKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (RotationStats{Mappings: 2, TokenCache: 1, GatewaySettings: 1}) {
+		t.Errorf("RotateKeys() = %+v, want every row rotated", stats)
+	}
+
+	// Everything is under the active key now and reads without the retired one
+	secrets, err := store.ListMappingSecrets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range secrets {
+		if !strings.HasPrefix(secret.MerchantPrivateKey, encryptedPrefix+"k2:") {
+			t.Errorf("mapping %d is stored as %q, want it under k2", secret.ID, secret.MerchantPrivateKey)
+		}
+	}
+
+	current := NewStore(conn, testKeyring(t, "k2:"+testKey(2), "k2"))
+	for gatewayID, want := range map[string]string{"g1": "plain key", "g2": "old key"} {
+		mapping, err := current.GetMapping(ctx, gatewayID)
+		if err != nil || mapping.MerchantPrivateKey != want {
+			t.Errorf("GetMapping(%s) = %q, %v, want %q", gatewayID, mapping.MerchantPrivateKey, err, want)
+		}
+	}
+	cached, err := current.GetTokenCache(ctx, "merchant")
+	if err != nil || cached.AccessToken != "access" || cached.RefreshToken != "refresh" {
+		t.Errorf("GetTokenCache() = %+v, %v, want the original tokens", cached, err)
+	}
+	settings, err := current.GetGatewaySettings(ctx, "g2")
+	if err != nil || settings.Password != "password" {
+		t.Errorf("GetGatewaySettings() = %q, %v, want %q", settings.Password, err, "password")
+	}
+
+	// Second run has nothing left to do
+	stats, err = store.RotateKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (RotationStats{}) {
+		t.Errorf("second RotateKeys() = %+v, want nothing rotated", stats)
+	}
+}
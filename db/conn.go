@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+type ConnConfig struct {
+	// Read-only SQLite connections next to the single writer
+	ReadConns int
+	// How long a SQLite connection waits for a lock held by another one
+	BusyTimeout time.Duration
+}
+
+// Database connections. SQLite gets a pool of read-only connections next to a single writer,
+// so reads are not queued behind writes. PostgreSQL uses one pool for both.
+type Conn struct {
+	// Writer, also used for transactions and migrations
+	*sql.DB
+	reader  *sql.DB
+	Dialect Dialect
+}
+
+// Open the database the url points to: postgres:// and postgresql:// urls use PostgreSQL,
+// sqlite://<path> or a plain file path use SQLite
+func Open(databaseUrl string, config ConnConfig) (*Conn, error) {
+	if strings.HasPrefix(databaseUrl, "postgres://") || strings.HasPrefix(databaseUrl, "postgresql://") {
+		conn, err := sql.Open("pgx", databaseUrl)
+		if err != nil {
+			return nil, err
+		}
+		return NewConn(conn, conn, DialectPostgres), nil
+	}
+
+	// Anything else with a scheme is a typo or an unsupported database, not a file name
+	if scheme, _, ok := strings.Cut(databaseUrl, "://"); ok && scheme != "sqlite" {
+		return nil, fmt.Errorf("unsupported database url scheme %q", scheme)
+	}
+
+	path := strings.TrimPrefix(databaseUrl, "sqlite://")
+	busyTimeout := fmt.Sprintf("_pragma=busy_timeout(%d)", config.BusyTimeout.Milliseconds())
+
+	// WAL lets readers work while the writer commits, immediate transactions take
+	// the write lock upfront instead of failing to upgrade a read lock midway
+	writer, err := sql.Open("sqlite", fmt.Sprintf(
+		"file:%s?%s&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate",
+		path, busyTimeout,
+	))
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+
+	reader, err := sql.Open("sqlite", fmt.Sprintf("file:%s?%s&_pragma=query_only(1)", path, busyTimeout))
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	reader.SetMaxOpenConns(max(config.ReadConns, 1))
+
+	return NewConn(writer, reader, DialectSQLite), nil
+}
+
+// Wrap opened connections, reader may be the writer itself
+func NewConn(writer *sql.DB, reader *sql.DB, dialect Dialect) *Conn {
+	return &Conn{DB: writer, reader: reader, Dialect: dialect}
+}
+
+func (conn *Conn) Close() error {
+	if conn.reader == conn.DB {
+		return conn.DB.Close()
+	}
+	return errors.Join(conn.reader.Close(), conn.DB.Close())
+}
+
+// Only plain selects go to the reader, inserts and updates with RETURNING are queries too
+func isReadQuery(query string) bool {
+	for {
+		query = strings.TrimSpace(query)
+		if !strings.HasPrefix(query, "--") {
+			break
+		}
+		_, rest, ok := strings.Cut(query, "\n")
+		if !ok {
+			return false
+		}
+		query = rest
+	}
+	return len(query) >= 6 && strings.EqualFold(query[:6], "SELECT")
+}
+
+// Sends reads to the reader pool and everything else to the writer
+type routedDB struct {
+	writer DBTX
+	reader DBTX
+}
+
+func (r *routedDB) route(query string) DBTX {
+	if isReadQuery(query) {
+		return r.reader
+	}
+	return r.writer
+}
+
+func (r *routedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.writer.ExecContext(ctx, query, args...)
+}
+
+func (r *routedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return r.route(query).PrepareContext(ctx, query)
+}
+
+func (r *routedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return r.route(query).QueryContext(ctx, query, args...)
+}
+
+func (r *routedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return r.route(query).QueryRowContext(ctx, query, args...)
+}
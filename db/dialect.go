@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Database engine behind the connection, queries are written for SQLite and rebound for the others
+type Dialect string
+
+const (
+	DialectSQLite   Dialect = "sqlite"
+	DialectPostgres Dialect = "postgres"
+)
+
+var rebound sync.Map
+
+// Rewrite ? and ?NNN placeholders into the numbered $n form PostgreSQL expects. A bare ?
+// is numbered one past the largest number so far, the way SQLite does it.
+// Question marks in string literals, quoted identifiers and comments are kept.
+func (dialect Dialect) Rebind(query string) string {
+	if dialect != DialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	if cached, ok := rebound.Load(query); ok {
+		return cached.(string)
+	}
+
+	var builder strings.Builder
+	builder.Grow(len(query) + 16)
+	placeholder := 0
+	for i := 0; i < len(query); i++ {
+		switch char := query[i]; char {
+		case '\'', '"':
+			end := strings.IndexByte(query[i+1:], char)
+			if end < 0 {
+				builder.WriteString(query[i:])
+				i = len(query)
+				continue
+			}
+			builder.WriteString(query[i : i+end+2])
+			i += end + 1
+		case '-':
+			if i+1 < len(query) && query[i+1] == '-' {
+				end := strings.IndexByte(query[i:], '\n')
+				if end < 0 {
+					end = len(query) - i
+				}
+				builder.WriteString(query[i : i+end])
+				i += end - 1
+				continue
+			}
+			builder.WriteByte(char)
+		case '?':
+			digits := i + 1
+			for digits < len(query) && query[digits] >= '0' && query[digits] <= '9' {
+				digits++
+			}
+			if digits > i+1 {
+				number, _ := strconv.Atoi(query[i+1 : digits])
+				placeholder = max(placeholder, number)
+				builder.WriteByte('$')
+				builder.WriteString(query[i+1 : digits])
+				i = digits - 1
+				continue
+			}
+			placeholder++
+			builder.WriteByte('$')
+			builder.WriteString(strconv.Itoa(placeholder))
+		default:
+			builder.WriteByte(char)
+		}
+	}
+
+	result := builder.String()
+	rebound.Store(query, result)
+	return result
+}
+
+// Connection that rebinds every query for its dialect
+type rebindDB struct {
+	db      DBTX
+	dialect Dialect
+}
+
+func rebind(db DBTX, dialect Dialect) DBTX {
+	if dialect != DialectPostgres {
+		return db
+	}
+	return &rebindDB{db: db, dialect: dialect}
+}
+
+func (r *rebindDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.db.ExecContext(ctx, r.dialect.Rebind(query), args...)
+}
+
+func (r *rebindDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return r.db.PrepareContext(ctx, r.dialect.Rebind(query))
+}
+
+func (r *rebindDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return r.db.QueryContext(ctx, r.dialect.Rebind(query), args...)
+}
+
+func (r *rebindDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return r.db.QueryRowContext(ctx, r.dialect.Rebind(query), args...)
+}
@@ -0,0 +1,117 @@
+package db
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "no placeholders",
+			query: "SELECT 1",
+			want:  "SELECT 1",
+		},
+		{
+			name:  "sequential placeholders",
+			query: "UPDATE callback_outbox SET updated_at = ? WHERE id = ?",
+			want:  "UPDATE callback_outbox SET updated_at = $1 WHERE id = $2",
+		},
+		{
+			name:  "string literal",
+			query: "SELECT * FROM t WHERE status = 'pending?' AND id = ?",
+			want:  "SELECT * FROM t WHERE status = 'pending?' AND id = $1",
+		},
+		{
+			name:  "escaped quote in string literal",
+			query: "SELECT 'it''s ?', ?",
+			want:  "SELECT 'it''s ?', $1",
+		},
+		{
+			name:  "quoted identifier",
+			query: `SELECT "weird?column" FROM t WHERE id = ?`,
+			want:  `SELECT "weird?column" FROM t WHERE id = $1`,
+		},
+		{
+			name:  "line comment",
+			query: "-- name: Get? :one\nSELECT * FROM t -- why?\nWHERE id = ?",
+			want:  "-- name: Get? :one\nSELECT * FROM t -- why?\nWHERE id = $1",
+		},
+		{
+			name:  "comment at the end",
+			query: "SELECT ? -- trailing?",
+			want:  "SELECT $1 -- trailing?",
+		},
+		{
+			name:  "minus is not a comment",
+			query: "UPDATE t SET attempts = attempts - ? WHERE id = ?",
+			want:  "UPDATE t SET attempts = attempts - $1 WHERE id = $2",
+		},
+		{
+			name:  "unterminated string literal",
+			query: "SELECT ?, 'open ?",
+			want:  "SELECT $1, 'open ?",
+		},
+		{
+			name:  "numbered placeholders",
+			query: updateTokenCacheSecrets,
+			want: `-- name: UpdateTokenCacheSecrets :execrows
+UPDATE token_cache
+SET access_token = $1,
+    refresh_token = $2
+WHERE id = $3
+    AND access_token = $4
+    AND refresh_token = $5
+`,
+		},
+		{
+			name:  "numbered placeholders with literals and functions",
+			query: listTransactions,
+			want: `-- name: ListTransactions :many
+SELECT id, token, gateway_id, operation_type, amount, currency, provider_amount, provider_status, rp_status, sandbox, external_id, redirect_url, created_at, updated_at, polled_at FROM transactions
+WHERE rp_status = COALESCE($1, rp_status)
+    AND operation_type = COALESCE($2, operation_type)
+    AND created_at >= $3
+    AND created_at < $4
+ORDER BY created_at DESC, id DESC
+LIMIT $5
+`,
+		},
+		{
+			name:  "repeated numbered placeholder after bare ones",
+			query: "INSERT INTO t (a, b, created_at, updated_at) VALUES (?, ?, ?3, ?3)",
+			want:  "INSERT INTO t (a, b, created_at, updated_at) VALUES ($1, $2, $3, $3)",
+		},
+		{
+			name:  "bare placeholder continues after the largest number",
+			query: "SELECT ?2, ?1, ?",
+			want:  "SELECT $2, $1, $3",
+		},
+		{
+			name:  "multi digit placeholder",
+			query: "SELECT ?12, ?",
+			want:  "SELECT $12, $13",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DialectPostgres.Rebind(test.query); got != test.want {
+				t.Errorf("Rebind(%q)\n got: %q\nwant: %q", test.query, got, test.want)
+			}
+			// Cached result must be the same
+			if got := DialectPostgres.Rebind(test.query); got != test.want {
+				t.Errorf("cached Rebind(%q)\n got: %q\nwant: %q", test.query, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRebindSQLiteKeepsQuery(t *testing.T) {
+	for _, query := range []string{createTransaction, listPendingTransactions, "SELECT ?"} {
+		if got := DialectSQLite.Rebind(query); got != query {
+			t.Errorf("Rebind(%q) = %q, want the query unchanged", query, got)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Numbered up migrations per dialect, <version>_<name>.sql, applied in version order
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var ErrDatabaseNewer = errors.New("database schema is newer than this binary")
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`
+
+// Lock key that serializes migrations of PostgreSQL replicas starting at once
+const migrationLockKey = 7465627301
+
+// Embedded migrations of the dialect sorted by version
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %w", dialect, err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s does not start with a version number", entry.Name())
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(content)})
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s share a version", migrations[i-1].Name, migrations[i].Name)
+		}
+	}
+	return migrations, nil
+}
+
+// Latest applied migration version, zero if none was applied
+func SchemaVersion(ctx context.Context, conn *sql.DB) (int, error) {
+	var version int
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Versions the database is at and the binary expects
+func schemaVersions(ctx context.Context, conn *sql.DB, dialect Dialect) (int, []Migration, error) {
+	migrations, err := Migrations(dialect)
+	if err != nil {
+		return 0, nil, err
+	}
+	current, err := SchemaVersion(ctx, conn)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+	if current > latest {
+		return current, nil, fmt.Errorf("%w: database is at version %d, latest known is %d", ErrDatabaseNewer, current, latest)
+	}
+	return current, migrations, nil
+}
+
+// Error unless the database is at the latest version
+func CheckSchema(ctx context.Context, conn *sql.DB, dialect Dialect) error {
+	current, migrations, err := schemaVersions(ctx, conn, dialect)
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].Version; current < latest {
+		return fmt.Errorf("database schema is at version %d, expected %d", current, latest)
+	}
+	return nil
+}
+
+// Apply pending migrations, each one in its own transaction together with its schema_migrations row
+func Migrate(ctx context.Context, conn *sql.DB, dialect Dialect) ([]Migration, error) {
+	if _, err := conn.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	current, migrations, err := schemaVersions(ctx, conn, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		ok, err := applyMigration(ctx, conn, dialect, migration)
+		if err != nil {
+			return applied, fmt.Errorf("migration %s: %w", migration.Name, err)
+		}
+		if ok {
+			applied = append(applied, migration)
+		}
+	}
+	return applied, nil
+}
+
+// Apply the migration unless another instance has done it in the meantime
+func applyMigration(ctx context.Context, conn *sql.DB, dialect Dialect, migration Migration) (bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if dialect == DialectPostgres {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockKey); err != nil {
+			return false, fmt.Errorf("failed to take migration lock: %w", err)
+		}
+	}
+
+	var applied int
+	if err := tx.QueryRowContext(ctx,
+		dialect.Rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"),
+		migration.Version,
+	).Scan(&applied); err != nil {
+		return false, err
+	}
+	if applied > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		dialect.Rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+		migration.Version, migration.Name, time.Now().UTC(),
+	); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,94 @@
+CREATE TABLE IF NOT EXISTS gateway_id_mapping (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    gateway_id TEXT NOT NULL UNIQUE,
+    token TEXT NOT NULL,
+    merchant_private_key TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS token_cache (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    credentials_hash TEXT NOT NULL UNIQUE,
+    access_token TEXT NOT NULL,
+    access_refreshed_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    refresh_token TEXT NOT NULL,
+    refresh_refreshed_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS callback_outbox (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    gateway_id TEXT NOT NULL,
+    token TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS callback_outbox_due ON callback_outbox (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS gateway_settings (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    gateway_id TEXT NOT NULL UNIQUE,
+    login TEXT NOT NULL,
+    password TEXT NOT NULL,
+    sandbox BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS callback_rejections (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    path TEXT NOT NULL,
+    remote_addr TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    token TEXT NOT NULL UNIQUE,
+    gateway_id TEXT UNIQUE,
+    operation_type TEXT NOT NULL,
+    amount BIGINT NOT NULL,
+    currency TEXT,
+    provider_amount DOUBLE PRECISION,
+    provider_status TEXT,
+    rp_status TEXT NOT NULL,
+    sandbox BOOLEAN NOT NULL,
+    external_id TEXT NOT NULL,
+    redirect_url TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS status_transitions (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    gateway_id TEXT NOT NULL,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    source TEXT NOT NULL,
+    accepted BOOLEAN NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS status_transitions_gateway_id ON status_transitions (gateway_id);
+
+CREATE TABLE IF NOT EXISTS interaction_logs (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    token TEXT NOT NULL,
+    gateway_id TEXT,
+    request_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    request_url TEXT,
+    request_params TEXT,
+    status INTEGER,
+    response TEXT,
+    duration DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS interaction_logs_token ON interaction_logs (token);
+CREATE INDEX IF NOT EXISTS interaction_logs_gateway_id ON interaction_logs (gateway_id);
+CREATE INDEX IF NOT EXISTS interaction_logs_created_at ON interaction_logs (created_at);
@@ -0,0 +1,2 @@
+-- Correlation id of the request that produced the business callback, sent along with it
+ALTER TABLE callback_outbox ADD COLUMN request_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Last status poll of a pending transaction, kept apart from updated_at so polling does not look like a change
+ALTER TABLE transactions ADD COLUMN polled_at DATETIME;
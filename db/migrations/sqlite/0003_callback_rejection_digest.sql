@@ -0,0 +1,4 @@
+-- Rejected callbacks keep only a prefix of the body, size and digest identify the rest
+ALTER TABLE callback_rejections ADD COLUMN body_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE callback_rejections ADD COLUMN body_sha256 TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS callback_rejections_created_at ON callback_rejections (created_at);
@@ -5,9 +5,35 @@
 package db
 
 import (
+	"database/sql"
 	"time"
 )
 
+type CallbackOutbox struct {
+	ID            int64          `json:"id"`
+	GatewayID     string         `json:"gateway_id"`
+	Token         string         `json:"token"`
+	Payload       string         `json:"payload"`
+	Status        string         `json:"status"`
+	Attempts      int64          `json:"attempts"`
+	LastError     sql.NullString `json:"last_error"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	RequestID     string         `json:"request_id"`
+}
+
+type CallbackRejection struct {
+	ID         int64     `json:"id"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr"`
+	Reason     string    `json:"reason"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	BodySize   int64     `json:"body_size"`
+	BodySha256 string    `json:"body_sha256"`
+}
+
 type GatewayIDMapping struct {
 	ID                 int64  `json:"id"`
 	GatewayID          string `json:"gateway_id"`
@@ -15,6 +41,38 @@ type GatewayIDMapping struct {
 	MerchantPrivateKey string `json:"merchant_private_key"`
 }
 
+type GatewaySetting struct {
+	ID        int64  `json:"id"`
+	GatewayID string `json:"gateway_id"`
+	Login     string `json:"login"`
+	Password  string `json:"password"`
+	Sandbox   bool   `json:"sandbox"`
+}
+
+type InteractionLog struct {
+	ID            int64          `json:"id"`
+	Token         string         `json:"token"`
+	GatewayID     sql.NullString `json:"gateway_id"`
+	RequestID     string         `json:"request_id"`
+	Kind          string         `json:"kind"`
+	RequestUrl    sql.NullString `json:"request_url"`
+	RequestParams sql.NullString `json:"request_params"`
+	Status        sql.NullInt64  `json:"status"`
+	Response      sql.NullString `json:"response"`
+	Duration      float64        `json:"duration"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+type StatusTransition struct {
+	ID         int64          `json:"id"`
+	GatewayID  string         `json:"gateway_id"`
+	FromStatus sql.NullString `json:"from_status"`
+	ToStatus   string         `json:"to_status"`
+	Source     string         `json:"source"`
+	Accepted   bool           `json:"accepted"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
 type TokenCache struct {
 	ID                 int64     `json:"id"`
 	CredentialsHash    string    `json:"credentials_hash"`
@@ -23,3 +81,21 @@ type TokenCache struct {
 	RefreshToken       string    `json:"refresh_token"`
 	RefreshRefreshedAt time.Time `json:"refresh_refreshed_at"`
 }
+
+type Transaction struct {
+	ID             int64           `json:"id"`
+	Token          string          `json:"token"`
+	GatewayID      sql.NullString  `json:"gateway_id"`
+	OperationType  string          `json:"operation_type"`
+	Amount         int64           `json:"amount"`
+	Currency       sql.NullString  `json:"currency"`
+	ProviderAmount sql.NullFloat64 `json:"provider_amount"`
+	ProviderStatus sql.NullString  `json:"provider_status"`
+	RpStatus       string          `json:"rp_status"`
+	Sandbox        bool            `json:"sandbox"`
+	ExternalID     string          `json:"external_id"`
+	RedirectUrl    sql.NullString  `json:"redirect_url"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	PolledAt       sql.NullTime    `json:"polled_at"`
+}
@@ -7,9 +7,141 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
+const claimCallback = `-- name: ClaimCallback :execrows
+UPDATE callback_outbox
+SET next_attempt_at = ?1
+WHERE id = ?2 AND status = 'pending' AND next_attempt_at <= ?3
+`
+
+type ClaimCallbackParams struct {
+	LeaseUntil time.Time `json:"lease_until"`
+	ID         int64     `json:"id"`
+	Now        time.Time `json:"now"`
+}
+
+func (q *Queries) ClaimCallback(ctx context.Context, arg ClaimCallbackParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimCallback, arg.LeaseUntil, arg.ID, arg.Now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimTransactionPoll = `-- name: ClaimTransactionPoll :execrows
+UPDATE transactions
+SET polled_at = ?1
+WHERE id = ?2 AND COALESCE(polled_at, created_at) <= ?3
+`
+
+type ClaimTransactionPollParams struct {
+	Now         sql.NullTime `json:"now"`
+	ID          int64        `json:"id"`
+	StaleBefore sql.NullTime `json:"stale_before"`
+}
+
+func (q *Queries) ClaimTransactionPoll(ctx context.Context, arg ClaimTransactionPollParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimTransactionPoll, arg.Now, arg.ID, arg.StaleBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createCallbackRejection = `-- name: CreateCallbackRejection :exec
+INSERT INTO callback_rejections (path, remote_addr, reason, body, body_size, body_sha256) VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type CreateCallbackRejectionParams struct {
+	Path       string `json:"path"`
+	RemoteAddr string `json:"remote_addr"`
+	Reason     string `json:"reason"`
+	Body       string `json:"body"`
+	BodySize   int64  `json:"body_size"`
+	BodySha256 string `json:"body_sha256"`
+}
+
+func (q *Queries) CreateCallbackRejection(ctx context.Context, arg CreateCallbackRejectionParams) error {
+	_, err := q.db.ExecContext(ctx, createCallbackRejection,
+		arg.Path,
+		arg.RemoteAddr,
+		arg.Reason,
+		arg.Body,
+		arg.BodySize,
+		arg.BodySha256,
+	)
+	return err
+}
+
+const createGatewaySettings = `-- name: CreateGatewaySettings :exec
+INSERT INTO gateway_settings (gateway_id, login, password, sandbox) VALUES (?, ?, ?, ?)
+`
+
+type CreateGatewaySettingsParams struct {
+	GatewayID string `json:"gateway_id"`
+	Login     string `json:"login"`
+	Password  string `json:"password"`
+	Sandbox   bool   `json:"sandbox"`
+}
+
+func (q *Queries) CreateGatewaySettings(ctx context.Context, arg CreateGatewaySettingsParams) error {
+	_, err := q.db.ExecContext(ctx, createGatewaySettings,
+		arg.GatewayID,
+		arg.Login,
+		arg.Password,
+		arg.Sandbox,
+	)
+	return err
+}
+
+const createInteractionLog = `-- name: CreateInteractionLog :exec
+INSERT INTO interaction_logs (
+    token,
+    gateway_id,
+    request_id,
+    kind,
+    request_url,
+    request_params,
+    status,
+    response,
+    duration,
+    created_at
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateInteractionLogParams struct {
+	Token         string         `json:"token"`
+	GatewayID     sql.NullString `json:"gateway_id"`
+	RequestID     string         `json:"request_id"`
+	Kind          string         `json:"kind"`
+	RequestUrl    sql.NullString `json:"request_url"`
+	RequestParams sql.NullString `json:"request_params"`
+	Status        sql.NullInt64  `json:"status"`
+	Response      sql.NullString `json:"response"`
+	Duration      float64        `json:"duration"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+func (q *Queries) CreateInteractionLog(ctx context.Context, arg CreateInteractionLogParams) error {
+	_, err := q.db.ExecContext(ctx, createInteractionLog,
+		arg.Token,
+		arg.GatewayID,
+		arg.RequestID,
+		arg.Kind,
+		arg.RequestUrl,
+		arg.RequestParams,
+		arg.Status,
+		arg.Response,
+		arg.Duration,
+		arg.CreatedAt,
+	)
+	return err
+}
+
 const createMapping = `-- name: CreateMapping :one
 INSERT INTO gateway_id_mapping (token, merchant_private_key, gateway_id) VALUES (?, ?, ?) RETURNING id, gateway_id, token, merchant_private_key
 `
@@ -32,6 +164,148 @@ func (q *Queries) CreateMapping(ctx context.Context, arg CreateMappingParams) (G
 	return i, err
 }
 
+const createStatusTransition = `-- name: CreateStatusTransition :exec
+INSERT INTO status_transitions (gateway_id, from_status, to_status, source, accepted, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type CreateStatusTransitionParams struct {
+	GatewayID  string         `json:"gateway_id"`
+	FromStatus sql.NullString `json:"from_status"`
+	ToStatus   string         `json:"to_status"`
+	Source     string         `json:"source"`
+	Accepted   bool           `json:"accepted"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+func (q *Queries) CreateStatusTransition(ctx context.Context, arg CreateStatusTransitionParams) error {
+	_, err := q.db.ExecContext(ctx, createStatusTransition,
+		arg.GatewayID,
+		arg.FromStatus,
+		arg.ToStatus,
+		arg.Source,
+		arg.Accepted,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createTransaction = `-- name: CreateTransaction :one
+INSERT INTO transactions (
+    token,
+    operation_type,
+    amount,
+    currency,
+    rp_status,
+    sandbox,
+    external_id,
+    created_at,
+    updated_at
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?8, ?8)
+RETURNING id, token, gateway_id, operation_type, amount, currency, provider_amount, provider_status, rp_status, sandbox, external_id, redirect_url, created_at, updated_at, polled_at
+`
+
+type CreateTransactionParams struct {
+	Token         string         `json:"token"`
+	OperationType string         `json:"operation_type"`
+	Amount        int64          `json:"amount"`
+	Currency      sql.NullString `json:"currency"`
+	RpStatus      string         `json:"rp_status"`
+	Sandbox       bool           `json:"sandbox"`
+	ExternalID    string         `json:"external_id"`
+	Now           time.Time      `json:"now"`
+}
+
+func (q *Queries) CreateTransaction(ctx context.Context, arg CreateTransactionParams) (Transaction, error) {
+	row := q.db.QueryRowContext(ctx, createTransaction,
+		arg.Token,
+		arg.OperationType,
+		arg.Amount,
+		arg.Currency,
+		arg.RpStatus,
+		arg.Sandbox,
+		arg.ExternalID,
+		arg.Now,
+	)
+	var i Transaction
+	err := row.Scan(
+		&i.ID,
+		&i.Token,
+		&i.GatewayID,
+		&i.OperationType,
+		&i.Amount,
+		&i.Currency,
+		&i.ProviderAmount,
+		&i.ProviderStatus,
+		&i.RpStatus,
+		&i.Sandbox,
+		&i.ExternalID,
+		&i.RedirectUrl,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PolledAt,
+	)
+	return i, err
+}
+
+const enqueueCallback = `-- name: EnqueueCallback :one
+INSERT INTO callback_outbox (gateway_id, token, payload, next_attempt_at, request_id)
+VALUES (?, ?, ?, ?, ?)
+RETURNING id, gateway_id, token, payload, status, attempts, last_error, next_attempt_at, created_at, updated_at, request_id
+`
+
+type EnqueueCallbackParams struct {
+	GatewayID     string    `json:"gateway_id"`
+	Token         string    `json:"token"`
+	Payload       string    `json:"payload"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	RequestID     string    `json:"request_id"`
+}
+
+func (q *Queries) EnqueueCallback(ctx context.Context, arg EnqueueCallbackParams) (CallbackOutbox, error) {
+	row := q.db.QueryRowContext(ctx, enqueueCallback,
+		arg.GatewayID,
+		arg.Token,
+		arg.Payload,
+		arg.NextAttemptAt,
+		arg.RequestID,
+	)
+	var i CallbackOutbox
+	err := row.Scan(
+		&i.ID,
+		&i.GatewayID,
+		&i.Token,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.NextAttemptAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.RequestID,
+	)
+	return i, err
+}
+
+const getGatewaySettings = `-- name: GetGatewaySettings :one
+SELECT id, gateway_id, login, password, sandbox FROM gateway_settings
+WHERE gateway_id = ? LIMIT 1
+`
+
+func (q *Queries) GetGatewaySettings(ctx context.Context, gatewayID string) (GatewaySetting, error) {
+	row := q.db.QueryRowContext(ctx, getGatewaySettings, gatewayID)
+	var i GatewaySetting
+	err := row.Scan(
+		&i.ID,
+		&i.GatewayID,
+		&i.Login,
+		&i.Password,
+		&i.Sandbox,
+	)
+	return i, err
+}
+
 const getMapping = `-- name: GetMapping :one
 SELECT id, gateway_id, token, merchant_private_key FROM gateway_id_mapping
 WHERE gateway_id = ? LIMIT 1
@@ -78,6 +352,693 @@ func (q *Queries) GetTokenCache(ctx context.Context, credentialsHash string) (Ge
 	return i, err
 }
 
+const getTransactionByGatewayID = `-- name: GetTransactionByGatewayID :one
+SELECT id, token, gateway_id, operation_type, amount, currency, provider_amount, provider_status, rp_status, sandbox, external_id, redirect_url, created_at, updated_at, polled_at FROM transactions
+WHERE gateway_id = ? LIMIT 1
+`
+
+func (q *Queries) GetTransactionByGatewayID(ctx context.Context, gatewayID sql.NullString) (Transaction, error) {
+	row := q.db.QueryRowContext(ctx, getTransactionByGatewayID, gatewayID)
+	var i Transaction
+	err := row.Scan(
+		&i.ID,
+		&i.Token,
+		&i.GatewayID,
+		&i.OperationType,
+		&i.Amount,
+		&i.Currency,
+		&i.ProviderAmount,
+		&i.ProviderStatus,
+		&i.RpStatus,
+		&i.Sandbox,
+		&i.ExternalID,
+		&i.RedirectUrl,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PolledAt,
+	)
+	return i, err
+}
+
+const getTransactionByToken = `-- name: GetTransactionByToken :one
+SELECT id, token, gateway_id, operation_type, amount, currency, provider_amount, provider_status, rp_status, sandbox, external_id, redirect_url, created_at, updated_at, polled_at FROM transactions
+WHERE token = ? LIMIT 1
+`
+
+func (q *Queries) GetTransactionByToken(ctx context.Context, token string) (Transaction, error) {
+	row := q.db.QueryRowContext(ctx, getTransactionByToken, token)
+	var i Transaction
+	err := row.Scan(
+		&i.ID,
+		&i.Token,
+		&i.GatewayID,
+		&i.OperationType,
+		&i.Amount,
+		&i.Currency,
+		&i.ProviderAmount,
+		&i.ProviderStatus,
+		&i.RpStatus,
+		&i.Sandbox,
+		&i.ExternalID,
+		&i.RedirectUrl,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PolledAt,
+	)
+	return i, err
+}
+
+const listCallbacksByGatewayID = `-- name: ListCallbacksByGatewayID :many
+SELECT id, gateway_id, token, payload, status, attempts, last_error, next_attempt_at, created_at, updated_at, request_id FROM callback_outbox
+WHERE gateway_id = ?
+ORDER BY created_at, id
+`
+
+func (q *Queries) ListCallbacksByGatewayID(ctx context.Context, gatewayID string) ([]CallbackOutbox, error) {
+	rows, err := q.db.QueryContext(ctx, listCallbacksByGatewayID, gatewayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CallbackOutbox
+	for rows.Next() {
+		var i CallbackOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.GatewayID,
+			&i.Token,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.LastError,
+			&i.NextAttemptAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.RequestID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDueCallbacks = `-- name: ListDueCallbacks :many
+SELECT id, gateway_id, token, payload, status, attempts, last_error, next_attempt_at, created_at, updated_at, request_id FROM callback_outbox
+WHERE status = 'pending' AND next_attempt_at <= ?
+ORDER BY next_attempt_at
+LIMIT ?
+`
+
+type ListDueCallbacksParams struct {
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	Limit         int64     `json:"limit"`
+}
+
+func (q *Queries) ListDueCallbacks(ctx context.Context, arg ListDueCallbacksParams) ([]CallbackOutbox, error) {
+	rows, err := q.db.QueryContext(ctx, listDueCallbacks, arg.NextAttemptAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CallbackOutbox
+	for rows.Next() {
+		var i CallbackOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.GatewayID,
+			&i.Token,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.LastError,
+			&i.NextAttemptAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.RequestID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGatewaySettingsSecrets = `-- name: ListGatewaySettingsSecrets :many
+SELECT id, password FROM gateway_settings
+ORDER BY id
+`
+
+type ListGatewaySettingsSecretsRow struct {
+	ID       int64  `json:"id"`
+	Password string `json:"password"`
+}
+
+func (q *Queries) ListGatewaySettingsSecrets(ctx context.Context) ([]ListGatewaySettingsSecretsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listGatewaySettingsSecrets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListGatewaySettingsSecretsRow
+	for rows.Next() {
+		var i ListGatewaySettingsSecretsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Password,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInteractionLogs = `-- name: ListInteractionLogs :many
+SELECT id, token, gateway_id, request_id, kind, request_url, request_params, status, response, duration, created_at FROM interaction_logs
+WHERE token = ?1 OR gateway_id = ?2
+ORDER BY created_at, id
+`
+
+type ListInteractionLogsParams struct {
+	Token     string         `json:"token"`
+	GatewayID sql.NullString `json:"gateway_id"`
+}
+
+func (q *Queries) ListInteractionLogs(ctx context.Context, arg ListInteractionLogsParams) ([]InteractionLog, error) {
+	rows, err := q.db.QueryContext(ctx, listInteractionLogs, arg.Token, arg.GatewayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []InteractionLog
+	for rows.Next() {
+		var i InteractionLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.Token,
+			&i.GatewayID,
+			&i.RequestID,
+			&i.Kind,
+			&i.RequestUrl,
+			&i.RequestParams,
+			&i.Status,
+			&i.Response,
+			&i.Duration,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMappingSecrets = `-- name: ListMappingSecrets :many
+SELECT id, merchant_private_key FROM gateway_id_mapping
+ORDER BY id
+`
+
+type ListMappingSecretsRow struct {
+	ID                 int64  `json:"id"`
+	MerchantPrivateKey string `json:"merchant_private_key"`
+}
+
+func (q *Queries) ListMappingSecrets(ctx context.Context) ([]ListMappingSecretsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listMappingSecrets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMappingSecretsRow
+	for rows.Next() {
+		var i ListMappingSecretsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.MerchantPrivateKey,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingTransactions = `-- name: ListPendingTransactions :many
+SELECT id, token, gateway_id, operation_type, amount, currency, provider_amount, provider_status, rp_status, sandbox, external_id, redirect_url, created_at, updated_at, polled_at FROM transactions
+WHERE rp_status = 'pending'
+    AND gateway_id IS NOT NULL
+    AND created_at >= ?1
+    AND COALESCE(polled_at, created_at) <= ?2
+ORDER BY COALESCE(polled_at, created_at)
+LIMIT ?3
+`
+
+type ListPendingTransactionsParams struct {
+	CreatedAfter time.Time    `json:"created_after"`
+	StaleBefore  sql.NullTime `json:"stale_before"`
+	Limit        int64        `json:"limit"`
+}
+
+func (q *Queries) ListPendingTransactions(ctx context.Context, arg ListPendingTransactionsParams) ([]Transaction, error) {
+	rows, err := q.db.QueryContext(ctx, listPendingTransactions, arg.CreatedAfter, arg.StaleBefore, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Transaction
+	for rows.Next() {
+		var i Transaction
+		if err := rows.Scan(
+			&i.ID,
+			&i.Token,
+			&i.GatewayID,
+			&i.OperationType,
+			&i.Amount,
+			&i.Currency,
+			&i.ProviderAmount,
+			&i.ProviderStatus,
+			&i.RpStatus,
+			&i.Sandbox,
+			&i.ExternalID,
+			&i.RedirectUrl,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.PolledAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTokenCacheSecrets = `-- name: ListTokenCacheSecrets :many
+SELECT id, access_token, refresh_token FROM token_cache
+ORDER BY id
+`
+
+type ListTokenCacheSecretsRow struct {
+	ID           int64  `json:"id"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (q *Queries) ListTokenCacheSecrets(ctx context.Context) ([]ListTokenCacheSecretsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTokenCacheSecrets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTokenCacheSecretsRow
+	for rows.Next() {
+		var i ListTokenCacheSecretsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.AccessToken,
+			&i.RefreshToken,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTransactions = `-- name: ListTransactions :many
+SELECT id, token, gateway_id, operation_type, amount, currency, provider_amount, provider_status, rp_status, sandbox, external_id, redirect_url, created_at, updated_at, polled_at FROM transactions
+WHERE rp_status = COALESCE(?1, rp_status)
+    AND operation_type = COALESCE(?2, operation_type)
+    AND created_at >= ?3
+    AND created_at < ?4
+ORDER BY created_at DESC, id DESC
+LIMIT ?5
+`
+
+type ListTransactionsParams struct {
+	RpStatus      sql.NullString `json:"rp_status"`
+	OperationType sql.NullString `json:"operation_type"`
+	CreatedAfter  time.Time      `json:"created_after"`
+	CreatedBefore time.Time      `json:"created_before"`
+	Limit         int64          `json:"limit"`
+}
+
+func (q *Queries) ListTransactions(ctx context.Context, arg ListTransactionsParams) ([]Transaction, error) {
+	rows, err := q.db.QueryContext(ctx, listTransactions,
+		arg.RpStatus,
+		arg.OperationType,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Transaction
+	for rows.Next() {
+		var i Transaction
+		if err := rows.Scan(
+			&i.ID,
+			&i.Token,
+			&i.GatewayID,
+			&i.OperationType,
+			&i.Amount,
+			&i.Currency,
+			&i.ProviderAmount,
+			&i.ProviderStatus,
+			&i.RpStatus,
+			&i.Sandbox,
+			&i.ExternalID,
+			&i.RedirectUrl,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.PolledAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markCallbackDelivered = `-- name: MarkCallbackDelivered :exec
+UPDATE callback_outbox
+SET status = 'delivered',
+    attempts = attempts + 1,
+    last_error = NULL,
+    updated_at = ?
+WHERE id = ?
+`
+
+type MarkCallbackDeliveredParams struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	ID        int64     `json:"id"`
+}
+
+func (q *Queries) MarkCallbackDelivered(ctx context.Context, arg MarkCallbackDeliveredParams) error {
+	_, err := q.db.ExecContext(ctx, markCallbackDelivered, arg.UpdatedAt, arg.ID)
+	return err
+}
+
+const markCallbackFailed = `-- name: MarkCallbackFailed :exec
+UPDATE callback_outbox
+SET status = ?,
+    attempts = attempts + 1,
+    last_error = ?,
+    next_attempt_at = ?,
+    updated_at = ?
+WHERE id = ?
+`
+
+type MarkCallbackFailedParams struct {
+	Status        string         `json:"status"`
+	LastError     sql.NullString `json:"last_error"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	ID            int64          `json:"id"`
+}
+
+func (q *Queries) MarkCallbackFailed(ctx context.Context, arg MarkCallbackFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markCallbackFailed,
+		arg.Status,
+		arg.LastError,
+		arg.NextAttemptAt,
+		arg.UpdatedAt,
+		arg.ID,
+	)
+	return err
+}
+
+const purgeCallbackRejections = `-- name: PurgeCallbackRejections :execrows
+DELETE FROM callback_rejections
+WHERE created_at < ?
+`
+
+func (q *Queries) PurgeCallbackRejections(ctx context.Context, createdAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeCallbackRejections, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeInteractionLogs = `-- name: PurgeInteractionLogs :execrows
+DELETE FROM interaction_logs
+WHERE created_at < ?
+`
+
+func (q *Queries) PurgeInteractionLogs(ctx context.Context, createdAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeInteractionLogs, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const searchMappings = `-- name: SearchMappings :many
+SELECT id, gateway_id, token FROM gateway_id_mapping
+WHERE token = ?1 OR gateway_id = ?2
+ORDER BY id
+`
+
+type SearchMappingsParams struct {
+	Token     string `json:"token"`
+	GatewayID string `json:"gateway_id"`
+}
+
+type SearchMappingsRow struct {
+	ID        int64  `json:"id"`
+	GatewayID string `json:"gateway_id"`
+	Token     string `json:"token"`
+}
+
+func (q *Queries) SearchMappings(ctx context.Context, arg SearchMappingsParams) ([]SearchMappingsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchMappings, arg.Token, arg.GatewayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchMappingsRow
+	for rows.Next() {
+		var i SearchMappingsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.GatewayID,
+			&i.Token,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setTransactionGateway = `-- name: SetTransactionGateway :exec
+UPDATE transactions
+SET gateway_id = ?,
+    provider_amount = ?,
+    provider_status = ?,
+    rp_status = ?,
+    redirect_url = ?,
+    updated_at = ?
+WHERE token = ?
+`
+
+type SetTransactionGatewayParams struct {
+	GatewayID      sql.NullString  `json:"gateway_id"`
+	ProviderAmount sql.NullFloat64 `json:"provider_amount"`
+	ProviderStatus sql.NullString  `json:"provider_status"`
+	RpStatus       string          `json:"rp_status"`
+	RedirectUrl    sql.NullString  `json:"redirect_url"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	Token          string          `json:"token"`
+}
+
+func (q *Queries) SetTransactionGateway(ctx context.Context, arg SetTransactionGatewayParams) error {
+	_, err := q.db.ExecContext(ctx, setTransactionGateway,
+		arg.GatewayID,
+		arg.ProviderAmount,
+		arg.ProviderStatus,
+		arg.RpStatus,
+		arg.RedirectUrl,
+		arg.UpdatedAt,
+		arg.Token,
+	)
+	return err
+}
+
+const setTransactionRPStatus = `-- name: SetTransactionRPStatus :exec
+UPDATE transactions
+SET rp_status = ?,
+    updated_at = ?
+WHERE token = ?
+`
+
+type SetTransactionRPStatusParams struct {
+	RpStatus  string    `json:"rp_status"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Token     string    `json:"token"`
+}
+
+func (q *Queries) SetTransactionRPStatus(ctx context.Context, arg SetTransactionRPStatusParams) error {
+	_, err := q.db.ExecContext(ctx, setTransactionRPStatus, arg.RpStatus, arg.UpdatedAt, arg.Token)
+	return err
+}
+
+const updateGatewaySettingsSecret = `-- name: UpdateGatewaySettingsSecret :execrows
+UPDATE gateway_settings
+SET password = ?1
+WHERE id = ?2 AND password = ?3
+`
+
+type UpdateGatewaySettingsSecretParams struct {
+	Password         string `json:"password"`
+	ID               int64  `json:"id"`
+	PreviousPassword string `json:"previous_password"`
+}
+
+func (q *Queries) UpdateGatewaySettingsSecret(ctx context.Context, arg UpdateGatewaySettingsSecretParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateGatewaySettingsSecret, arg.Password, arg.ID, arg.PreviousPassword)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateMappingSecret = `-- name: UpdateMappingSecret :execrows
+UPDATE gateway_id_mapping
+SET merchant_private_key = ?1
+WHERE id = ?2 AND merchant_private_key = ?3
+`
+
+type UpdateMappingSecretParams struct {
+	MerchantPrivateKey         string `json:"merchant_private_key"`
+	ID                         int64  `json:"id"`
+	PreviousMerchantPrivateKey string `json:"previous_merchant_private_key"`
+}
+
+func (q *Queries) UpdateMappingSecret(ctx context.Context, arg UpdateMappingSecretParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateMappingSecret, arg.MerchantPrivateKey, arg.ID, arg.PreviousMerchantPrivateKey)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateTokenCacheSecrets = `-- name: UpdateTokenCacheSecrets :execrows
+UPDATE token_cache
+SET access_token = ?1,
+    refresh_token = ?2
+WHERE id = ?3
+    AND access_token = ?4
+    AND refresh_token = ?5
+`
+
+type UpdateTokenCacheSecretsParams struct {
+	AccessToken          string `json:"access_token"`
+	RefreshToken         string `json:"refresh_token"`
+	ID                   int64  `json:"id"`
+	PreviousAccessToken  string `json:"previous_access_token"`
+	PreviousRefreshToken string `json:"previous_refresh_token"`
+}
+
+func (q *Queries) UpdateTokenCacheSecrets(ctx context.Context, arg UpdateTokenCacheSecretsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateTokenCacheSecrets,
+		arg.AccessToken,
+		arg.RefreshToken,
+		arg.ID,
+		arg.PreviousAccessToken,
+		arg.PreviousRefreshToken,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateTransactionStatus = `-- name: UpdateTransactionStatus :exec
+UPDATE transactions
+SET provider_status = ?,
+    provider_amount = ?,
+    rp_status = ?,
+    updated_at = ?
+WHERE gateway_id = ?
+`
+
+type UpdateTransactionStatusParams struct {
+	ProviderStatus sql.NullString  `json:"provider_status"`
+	ProviderAmount sql.NullFloat64 `json:"provider_amount"`
+	RpStatus       string          `json:"rp_status"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	GatewayID      sql.NullString  `json:"gateway_id"`
+}
+
+func (q *Queries) UpdateTransactionStatus(ctx context.Context, arg UpdateTransactionStatusParams) error {
+	_, err := q.db.ExecContext(ctx, updateTransactionStatus,
+		arg.ProviderStatus,
+		arg.ProviderAmount,
+		arg.RpStatus,
+		arg.UpdatedAt,
+		arg.GatewayID,
+	)
+	return err
+}
+
 const upsertTokenCache = `-- name: UpsertTokenCache :exec
 INSERT INTO token_cache (
     credentials_hash,
@@ -86,21 +1047,29 @@ INSERT INTO token_cache (
     access_refreshed_at,
     refresh_refreshed_at
 )
-VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+VALUES (?, ?, ?, ?, ?)
 ON CONFLICT(credentials_hash) DO UPDATE SET
     access_token        = excluded.access_token,
     refresh_token       = excluded.refresh_token,
-    access_refreshed_at = CURRENT_TIMESTAMP,
-    refresh_refreshed_at = CURRENT_TIMESTAMP
+    access_refreshed_at = excluded.access_refreshed_at,
+    refresh_refreshed_at = excluded.refresh_refreshed_at
 `
 
 type UpsertTokenCacheParams struct {
-	CredentialsHash string `json:"credentials_hash"`
-	AccessToken     string `json:"access_token"`
-	RefreshToken    string `json:"refresh_token"`
+	CredentialsHash    string    `json:"credentials_hash"`
+	AccessToken        string    `json:"access_token"`
+	RefreshToken       string    `json:"refresh_token"`
+	AccessRefreshedAt  time.Time `json:"access_refreshed_at"`
+	RefreshRefreshedAt time.Time `json:"refresh_refreshed_at"`
 }
 
 func (q *Queries) UpsertTokenCache(ctx context.Context, arg UpsertTokenCacheParams) error {
-	_, err := q.db.ExecContext(ctx, upsertTokenCache, arg.CredentialsHash, arg.AccessToken, arg.RefreshToken)
+	_, err := q.db.ExecContext(ctx, upsertTokenCache,
+		arg.CredentialsHash,
+		arg.AccessToken,
+		arg.RefreshToken,
+		arg.AccessRefreshedAt,
+		arg.RefreshRefreshedAt,
+	)
 	return err
 }
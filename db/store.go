@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Columns holding merchant secrets, names are authenticated with the encrypted value
+const (
+	columnMerchantPrivateKey = "gateway_id_mapping.merchant_private_key"
+	columnAccessToken        = "token_cache.access_token"
+	columnRefreshToken       = "token_cache.refresh_token"
+	columnGatewayPassword    = "gateway_settings.password"
+)
+
+// Queries that encrypt secret columns on write and decrypt them on read
+type Store struct {
+	*Queries
+	conn    *Conn
+	keyring *Keyring
+	// Set on the store of a transaction
+	tx bool
+}
+
+func NewStore(conn *Conn, keyring *Keyring) *Store {
+	var db DBTX = conn.DB
+	if conn.reader != conn.DB {
+		db = &routedDB{writer: conn.DB, reader: conn.reader}
+	}
+	return &Store{Queries: New(rebind(db, conn.Dialect)), conn: conn, keyring: keyring}
+}
+
+func (store *Store) Dialect() Dialect {
+	return store.conn.Dialect
+}
+
+// Run fn in a transaction on the writer, all its queries commit or roll back together.
+// Nested calls join the outer transaction.
+func (store *Store) InTx(ctx context.Context, fn func(queries *Store) error) error {
+	if store.tx {
+		return fn(store)
+	}
+
+	tx, err := store.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Store{
+		Queries: New(rebind(tx, store.conn.Dialect)),
+		conn:    store.conn,
+		keyring: store.keyring,
+		tx:      true,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Serialize transactions working on the same key across instances. On PostgreSQL this is
+// an advisory lock released on commit or rollback, SQLite transactions already hold the
+// write lock of the whole database.
+func (store *Store) LockKey(ctx context.Context, key string) error {
+	if !store.tx {
+		return errors.New("key lock must be taken in a transaction")
+	}
+	if store.conn.Dialect != DialectPostgres {
+		return nil
+	}
+	if _, err := store.db.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtextextended(?, 0))", key); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", key, err)
+	}
+	return nil
+}
+
+func (store *Store) CreateMapping(ctx context.Context, arg CreateMappingParams) (GatewayIDMapping, error) {
+	privateKey := arg.MerchantPrivateKey
+	encrypted, err := store.keyring.Encrypt(columnMerchantPrivateKey, privateKey)
+	if err != nil {
+		return GatewayIDMapping{}, err
+	}
+	arg.MerchantPrivateKey = encrypted
+
+	mapping, err := store.Queries.CreateMapping(ctx, arg)
+	mapping.MerchantPrivateKey = privateKey
+	return mapping, err
+}
+
+func (store *Store) decryptMapping(mapping GatewayIDMapping) (GatewayIDMapping, error) {
+	var err error
+	mapping.MerchantPrivateKey, err = store.keyring.Decrypt(columnMerchantPrivateKey, mapping.MerchantPrivateKey)
+	return mapping, err
+}
+
+func (store *Store) GetMapping(ctx context.Context, gatewayID string) (GatewayIDMapping, error) {
+	mapping, err := store.Queries.GetMapping(ctx, gatewayID)
+	if err != nil {
+		return mapping, err
+	}
+	return store.decryptMapping(mapping)
+}
+
+func (store *Store) UpsertTokenCache(ctx context.Context, arg UpsertTokenCacheParams) error {
+	var err error
+	if arg.AccessToken, err = store.keyring.Encrypt(columnAccessToken, arg.AccessToken); err != nil {
+		return err
+	}
+	if arg.RefreshToken, err = store.keyring.Encrypt(columnRefreshToken, arg.RefreshToken); err != nil {
+		return err
+	}
+	return store.Queries.UpsertTokenCache(ctx, arg)
+}
+
+func (store *Store) GetTokenCache(ctx context.Context, credentialsHash string) (GetTokenCacheRow, error) {
+	cached, err := store.Queries.GetTokenCache(ctx, credentialsHash)
+	if err != nil {
+		return cached, err
+	}
+	if cached.AccessToken, err = store.keyring.Decrypt(columnAccessToken, cached.AccessToken); err != nil {
+		return cached, err
+	}
+	cached.RefreshToken, err = store.keyring.Decrypt(columnRefreshToken, cached.RefreshToken)
+	return cached, err
+}
+
+func (store *Store) CreateGatewaySettings(ctx context.Context, arg CreateGatewaySettingsParams) error {
+	var err error
+	if arg.Password, err = store.keyring.Encrypt(columnGatewayPassword, arg.Password); err != nil {
+		return err
+	}
+	return store.Queries.CreateGatewaySettings(ctx, arg)
+}
+
+func (store *Store) GetGatewaySettings(ctx context.Context, gatewayID string) (GatewaySetting, error) {
+	settings, err := store.Queries.GetGatewaySettings(ctx, gatewayID)
+	if err != nil {
+		return settings, err
+	}
+	settings.Password, err = store.keyring.Decrypt(columnGatewayPassword, settings.Password)
+	return settings, err
+}
+
+// Rows re-encrypted by RotateKeys, per table
+type RotationStats struct {
+	Mappings        int64
+	TokenCache      int64
+	GatewaySettings int64
+}
+
+// Re-encrypt a secret with the active key, plaintext secrets get encrypted
+func (store *Store) reencrypt(column string, value string) (string, error) {
+	plaintext, err := store.keyring.Decrypt(column, value)
+	if err != nil {
+		return "", err
+	}
+	return store.keyring.Encrypt(column, plaintext)
+}
+
+// Re-encrypt every secret that is stored in plaintext or with a key other than the active one.
+// Safe to run next to a live server, a row updated in the meantime is left to the writer.
+func (store *Store) RotateKeys(ctx context.Context) (RotationStats, error) {
+	var stats RotationStats
+	if !store.keyring.Enabled() {
+		return stats, errors.New("no active encryption key")
+	}
+
+	mappings, err := store.ListMappingSecrets(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("failed to list mappings: %w", err)
+	}
+	for _, mapping := range mappings {
+		if !store.keyring.needsRotation(mapping.MerchantPrivateKey) {
+			continue
+		}
+		privateKey, err := store.reencrypt(columnMerchantPrivateKey, mapping.MerchantPrivateKey)
+		if err != nil {
+			return stats, fmt.Errorf("mapping %d: %w", mapping.ID, err)
+		}
+		updated, err := store.UpdateMappingSecret(ctx, UpdateMappingSecretParams{
+			MerchantPrivateKey:         privateKey,
+			ID:                         mapping.ID,
+			PreviousMerchantPrivateKey: mapping.MerchantPrivateKey,
+		})
+		if err != nil {
+			return stats, fmt.Errorf("failed to update mapping %d: %w", mapping.ID, err)
+		}
+		stats.Mappings += updated
+	}
+
+	tokens, err := store.ListTokenCacheSecrets(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("failed to list token cache: %w", err)
+	}
+	for _, cached := range tokens {
+		if !store.keyring.needsRotation(cached.AccessToken) && !store.keyring.needsRotation(cached.RefreshToken) {
+			continue
+		}
+		accessToken, err := store.reencrypt(columnAccessToken, cached.AccessToken)
+		if err != nil {
+			return stats, fmt.Errorf("token cache %d: %w", cached.ID, err)
+		}
+		refreshToken, err := store.reencrypt(columnRefreshToken, cached.RefreshToken)
+		if err != nil {
+			return stats, fmt.Errorf("token cache %d: %w", cached.ID, err)
+		}
+		updated, err := store.UpdateTokenCacheSecrets(ctx, UpdateTokenCacheSecretsParams{
+			AccessToken:          accessToken,
+			RefreshToken:         refreshToken,
+			ID:                   cached.ID,
+			PreviousAccessToken:  cached.AccessToken,
+			PreviousRefreshToken: cached.RefreshToken,
+		})
+		if err != nil {
+			return stats, fmt.Errorf("failed to update token cache %d: %w", cached.ID, err)
+		}
+		stats.TokenCache += updated
+	}
+
+	settings, err := store.ListGatewaySettingsSecrets(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("failed to list gateway settings: %w", err)
+	}
+	for _, setting := range settings {
+		if !store.keyring.needsRotation(setting.Password) {
+			continue
+		}
+		password, err := store.reencrypt(columnGatewayPassword, setting.Password)
+		if err != nil {
+			return stats, fmt.Errorf("gateway settings %d: %w", setting.ID, err)
+		}
+		updated, err := store.UpdateGatewaySettingsSecret(ctx, UpdateGatewaySettingsSecretParams{
+			Password:         password,
+			ID:               setting.ID,
+			PreviousPassword: setting.Password,
+		})
+		if err != nil {
+			return stats, fmt.Errorf("failed to update gateway settings %d: %w", setting.ID, err)
+		}
+		stats.GatewaySettings += updated
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,234 @@
+package gateway
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default circuit breaker settings
+const (
+	CIRCUIT_WINDOW             = 20
+	CIRCUIT_MIN_REQUESTS       = 10
+	CIRCUIT_FAILURE_RATE       = 0.5
+	CIRCUIT_OPEN_DURATION      = 30 * time.Second
+	CIRCUIT_HALF_OPEN_REQUESTS = 1
+)
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+type BreakerConfig struct {
+	// Number of recent requests the failure rate is computed over
+	Window int
+	// Circuit stays closed until the window holds this many requests
+	MinRequests int
+	// Share of failed requests in the window that opens the circuit
+	FailureRate float64
+	// How long the circuit fails fast before probing the provider again
+	OpenDuration time.Duration
+	// Probe requests let through at once while half open
+	HalfOpenRequests int
+}
+
+// Circuit breaker of a single provider base url.
+// Only unavailability counts as a failure: timeouts, network errors and 5xx responses.
+type Breaker struct {
+	url    string
+	config BreakerConfig
+
+	mu       sync.Mutex
+	state    CircuitState
+	openedAt time.Time
+	// Changes with every state transition, results of requests let through in another state are ignored
+	generation uint64
+	probes     int
+
+	outcomes []bool
+	next     int
+	count    int
+	failures int
+}
+
+type BreakerStatus struct {
+	Url      string       `json:"url"`
+	State    CircuitState `json:"state"`
+	Requests int          `json:"requests"`
+	Failures int          `json:"failures"`
+	OpenedAt *time.Time   `json:"opened_at,omitempty"`
+	RetryAt  *time.Time   `json:"retry_at,omitempty"`
+}
+
+func newBreaker(url string, config BreakerConfig) *Breaker {
+	return &Breaker{
+		url:      url,
+		config:   config,
+		state:    CircuitClosed,
+		outcomes: make([]bool, max(config.Window, 1)),
+	}
+}
+
+// Ask for permission to send a request, returns the generation to report the result with
+func (b *Breaker) allow(operation string) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		retryAt := b.openedAt.Add(b.config.OpenDuration)
+		if time.Now().Before(retryAt) {
+			return 0, b.openError(operation, retryAt)
+		}
+		b.transition(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if b.probes >= max(b.config.HalfOpenRequests, 1) {
+			return 0, b.openError(operation, time.Time{})
+		}
+		b.probes++
+	}
+
+	return b.generation, nil
+}
+
+func (b *Breaker) openError(operation string, retryAt time.Time) *Error {
+	err := fmt.Errorf("circuit breaker for %s is open", b.url)
+	if !retryAt.IsZero() {
+		err = fmt.Errorf("%w until %s", err, retryAt.UTC().Format(time.RFC3339))
+	}
+	return &Error{Kind: ErrorKindCircuitOpen, Operation: operation, Err: err}
+}
+
+// Report result of a request let through by allow
+func (b *Breaker) record(generation uint64, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case CircuitHalfOpen:
+		if failed {
+			b.transition(CircuitOpen)
+		} else {
+			b.transition(CircuitClosed)
+		}
+	case CircuitClosed:
+		b.observe(failed)
+		if b.count >= b.config.MinRequests && float64(b.failures)/float64(b.count) >= b.config.FailureRate {
+			b.transition(CircuitOpen)
+		}
+	}
+}
+
+// Forget about a request that ended without telling anything about the provider
+func (b *Breaker) release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation == b.generation && b.state == CircuitHalfOpen {
+		b.probes--
+	}
+}
+
+// Push the outcome into the window, dropping the oldest one when it is full
+func (b *Breaker) observe(failed bool) {
+	if b.count == len(b.outcomes) {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+	} else {
+		b.count++
+	}
+
+	b.outcomes[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+}
+
+func (b *Breaker) transition(state CircuitState) {
+	b.state = state
+	b.generation++
+	b.probes = 0
+
+	switch state {
+	case CircuitOpen:
+		b.openedAt = time.Now()
+	case CircuitClosed:
+		clear(b.outcomes)
+		b.next, b.count, b.failures = 0, 0, 0
+	}
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		Url:      b.url,
+		State:    b.state,
+		Requests: b.count,
+		Failures: b.failures,
+	}
+	if b.state != CircuitClosed {
+		openedAt := b.openedAt.UTC()
+		retryAt := openedAt.Add(b.config.OpenDuration)
+		status.OpenedAt = &openedAt
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// Circuit breakers by provider base url
+type Breakers struct {
+	config BreakerConfig
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+	urls     []string
+}
+
+func NewBreakers(config BreakerConfig, urls ...string) *Breakers {
+	breakers := &Breakers{config: config, breakers: make(map[string]*Breaker)}
+	for _, url := range urls {
+		breakers.Get(url)
+	}
+	return breakers
+}
+
+func (breakers *Breakers) Get(url string) *Breaker {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+
+	breaker, ok := breakers.breakers[url]
+	if !ok {
+		breaker = newBreaker(url, breakers.config)
+		breakers.breakers[url] = breaker
+		breakers.urls = append(breakers.urls, url)
+	}
+	return breaker
+}
+
+func (breakers *Breakers) Status() []BreakerStatus {
+	breakers.mu.Lock()
+	list := make([]*Breaker, 0, len(breakers.urls))
+	for _, url := range breakers.urls {
+		list = append(list, breakers.breakers[url])
+	}
+	breakers.mu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(list))
+	for _, breaker := range list {
+		statuses = append(statuses, breaker.Status())
+	}
+	return statuses
+}
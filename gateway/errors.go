@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dog4ik/stbl/metrics"
+)
+
+type ErrorKind string
+
+const (
+	// Provider rejected merchant credentials or the access token
+	ErrorKindAuth ErrorKind = "auth"
+	// Connect request lacks data the provider requires, nothing was sent
+	ErrorKindValidation ErrorKind = "validation"
+	// Provider refused the request with 4xx and explained why
+	ErrorKindRejected ErrorKind = "provider_rejected"
+	// Provider answered with 5xx
+	ErrorKindProviderFailure ErrorKind = "provider_failure"
+	// Provider did not answer before the deadline
+	ErrorKindTimeout ErrorKind = "timeout"
+	// Provider answer can not be interpreted
+	ErrorKindMalformed ErrorKind = "malformed_response"
+	// Connection to the provider failed
+	ErrorKindNetwork ErrorKind = "network"
+	// Provider is considered down, request was not sent
+	ErrorKindCircuitOpen ErrorKind = "circuit_open"
+	// Request budget of the merchant credentials is exhausted, request was not sent
+	ErrorKindRateLimited ErrorKind = "rate_limited"
+)
+
+// Failed provider call
+type Error struct {
+	Kind ErrorKind
+	// Provider operation, one of the metrics operations
+	Operation string
+	// Provider response status, zero if there was no response
+	Status int
+	// Reason reported by the provider
+	Detail string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	var msg string
+	switch e.Kind {
+	case ErrorKindRejected:
+		return e.Detail
+	case ErrorKindValidation:
+		return e.Err.Error()
+	case ErrorKindAuth:
+		msg = "provider rejected credentials"
+	case ErrorKindProviderFailure:
+		msg = "provider failed to process the request"
+	case ErrorKindTimeout:
+		msg = "provider request timed out"
+	case ErrorKindMalformed:
+		msg = "malformed provider response"
+	case ErrorKindNetwork:
+		msg = "provider request failed"
+	case ErrorKindCircuitOpen:
+		msg = "provider is unavailable"
+	case ErrorKindRateLimited:
+		msg = "too many provider requests"
+	}
+
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.Status)
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	} else if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Provider might have created the payment or payout even though the call failed,
+// so the operation must stay pending until its status is known
+func (e *Error) MightHaveSucceeded() bool {
+	if e.Operation != metrics.OperationPayment && e.Operation != metrics.OperationPayout {
+		return false
+	}
+
+	switch e.Kind {
+	case ErrorKindProviderFailure, ErrorKindTimeout, ErrorKindMalformed, ErrorKindNetwork:
+		return true
+	}
+	return false
+}
+
+// Typed gateway error from the chain, nil if the failure did not come from a provider call
+func AsError(err error) *Error {
+	var gatewayErr *Error
+	if errors.As(err, &gatewayErr) {
+		return gatewayErr
+	}
+	return nil
+}
+
+func validationError(operation string, msg string) *Error {
+	return &Error{Kind: ErrorKindValidation, Operation: operation, Err: errors.New(msg)}
+}
+
+// Request failed without a provider response
+func transportError(operation string, err error) *Error {
+	var netErr interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &Error{Kind: ErrorKindTimeout, Operation: operation, Err: err}
+	}
+	return &Error{Kind: ErrorKindNetwork, Operation: operation, Err: err}
+}
+
+// Provider answered with something else than the expected status
+func responseError(operation string, status int, body []byte) *Error {
+	gatewayErr := &Error{Operation: operation, Status: status}
+
+	var ge GatewayError
+	if err := json.Unmarshal(body, &ge); err == nil && ge.Detail != nil {
+		gatewayErr.Detail = *ge.Detail
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		gatewayErr.Kind = ErrorKindAuth
+	case status >= 500:
+		gatewayErr.Kind = ErrorKindProviderFailure
+	case status >= 400 && gatewayErr.Detail != "":
+		gatewayErr.Kind = ErrorKindRejected
+	default:
+		// Rejection without a reason or an unexpected success status
+		gatewayErr.Kind = ErrorKindMalformed
+	}
+	return gatewayErr
+}
+
+// Decode provider answer with the expected status
+func decodeResponse[T any](operation string, status int, expected int, body []byte) (T, error) {
+	var result T
+	if status != expected {
+		return result, responseError(operation, status, body)
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return result, &Error{Kind: ErrorKindMalformed, Operation: operation, Status: status, Err: err}
+	}
+	return result, nil
+}
+
+func missingFieldsError(operation string, status int) *Error {
+	return &Error{
+		Kind:      ErrorKindMalformed,
+		Operation: operation,
+		Status:    status,
+		Err:       errors.New("response is missing required fields"),
+	}
+}
@@ -6,29 +6,53 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/dog4ik/stbl/connect"
-	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/metrics"
 	"github.com/dog4ik/stbl/utils"
 )
 
 const ACCESS_TOKEN_TTL = 15 * time.Minute
+const REFRESH_TOKEN_TTL = 24 * time.Hour
 
 type GatewayError struct {
 	Detail *string `json:"detail"`
 }
 
+// Dependencies and policies shared by all gateway clients
+type ClientConfig struct {
+	Client         *http.Client
+	Tokens         *TokenStore
+	Breakers       *Breakers
+	Limiters       *Limiters
+	Timeouts       Timeouts
+	Retry          RetryPolicy
+	ProdBaseUrl    string
+	SandboxBaseUrl string
+}
+
 type GatewayClient struct {
-	client       *http.Client
-	refreshToken string
-	accessToken  string
-	baseUrl      string
-	callbackUrl  string
+	client    *http.Client
+	tokenPair tokenPair
+	baseUrl   string
+
+	tokens          *TokenStore
+	breaker         *Breaker
+	limiters        *Limiters
+	timeouts        Timeouts
+	retry           RetryPolicy
+	settings        connect.Settings
+	credentialsHash string
+	// Interaction logs of the request the client serves
+	logs   *connect.InteractionLogs
+	logger *slog.Logger
 }
 
 type AuthRequest struct {
@@ -45,212 +69,366 @@ type AuthResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-func obtainFreshTokens(client *http.Client, il *connect.InteractionLogs, baseURL, login, password string) (*AuthResponse, error) {
-	authReq := AuthRequest{
-		Username: login,
-		Password: password,
+// Send the request within the merchant's rate limit and through the circuit breaker of the base url
+func (self *GatewayClient) do(req *http.Request, operation string, timeout time.Duration) (*http.Response, error) {
+	if err := self.limiters.wait(req.Context(), self.credentialsHash, operation); err != nil {
+		return nil, err
 	}
-	jsonData, err := json.Marshal(authReq)
+
+	generation, err := self.breaker.allow(operation)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, err
 	}
 
-	logger := il.Enter("login")
-	url := baseURL + "/auth/api/v1/external-tokens/token-obtain"
-	logger.SetRequest(utils.SecureStruct(authReq), url)
+	setRequestID(req, self.logs.RequestID())
 
-	res, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	started := time.Now()
+	res, err := doWithTimeout(self.client, req, operation, timeout)
+	metrics.ObserveGatewayRequest(operation, self.settings.Sandbox, started, res)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// Caller gave up, that says nothing about the provider
+		self.breaker.release(generation)
+	case err != nil:
+		self.breaker.record(generation, true)
+	default:
+		self.breaker.record(generation, res.StatusCode >= 500)
+	}
+	return res, err
+}
+
+func (self *GatewayClient) postJSON(ctx context.Context, operation string, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("POST request failed: %v", err)
+		return nil, transportError(operation, err)
 	}
+
+	req.Header.Set("content-type", "application/json")
+	return self.do(req, operation, self.timeouts.Login)
+}
+
+// Read the whole response body and record it in the interaction log span
+func readBody(res *http.Response, operation string, logger *connect.LogWriter) ([]byte, error) {
 	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, transportError(operation, err)
+	}
+
+	utils.DecodeBody(bytes.NewReader(body), logger)
+	return body, nil
+}
+
+// Send token request, provider answers 4xx when it does not accept the credentials or the refresh token
+func (self *GatewayClient) requestTokens(ctx context.Context, logger *connect.LogWriter, operation string, url string, body []byte) (*AuthResponse, error) {
+	res, err := self.postJSON(ctx, operation, url, body)
+	if err != nil {
+		return nil, err
+	}
 	logger.SetStatus(res.StatusCode)
 
-	if res.StatusCode != http.StatusCreated {
-		utils.DecodeBody(res.Body, logger)
-		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	resBody, err := readBody(res, operation, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	body := utils.DecodeBody(res.Body, logger)
-	var authRes AuthResponse
-	if err := json.Unmarshal(body, &authRes); err != nil {
-		return nil, fmt.Errorf("failed to decode JSON response: %v", err)
+	authRes, err := decodeResponse[AuthResponse](operation, res.StatusCode, http.StatusCreated, resBody)
+	if gatewayErr := AsError(err); gatewayErr != nil && gatewayErr.Status >= 400 && gatewayErr.Status < 500 {
+		gatewayErr.Kind = ErrorKindAuth
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	return &authRes, nil
 }
 
-func refreshAccessToken(client *http.Client, il *connect.InteractionLogs, baseUrl, refreshToken string) (*AuthResponse, error) {
-	reqBody := RefreshRequest{
-		RefreshToken: refreshToken,
+// Propagate correlation id of the business request to the provider
+func setRequestID(req *http.Request, requestID string) {
+	if requestID != "" {
+		req.Header.Set(utils.RequestIDHeader, requestID)
 	}
-	jsonData, err := json.Marshal(reqBody)
+}
+
+func (self *GatewayClient) obtainFreshTokens(ctx context.Context) (*AuthResponse, error) {
+	authReq := AuthRequest{
+		Username: self.settings.Login,
+		Password: self.settings.Password,
+	}
+	jsonData, err := json.Marshal(authReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
 	}
 
-	logger := il.Enter("refresh_token")
-	url := baseUrl + "/auth/api/v1/external-tokens/token-refresh"
-	logger.SetRequest(string(jsonData), url)
+	logger := self.logs.Enter("login")
+	url := self.baseUrl + "/auth/api/v1/external-tokens/token-obtain"
+	logger.SetRequest(utils.SecureStruct(authReq), url)
 
-	res, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("POST request failed: %v", err)
-	}
-	defer res.Body.Close()
-	logger.SetStatus(res.StatusCode)
+	return self.requestTokens(ctx, logger, metrics.OperationLogin, url, jsonData)
+}
 
-	if res.StatusCode != http.StatusCreated {
-		utils.DecodeBody(res.Body, logger)
-		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+func (self *GatewayClient) refreshAccessToken(ctx context.Context, refreshToken string) (*AuthResponse, error) {
+	reqBody := RefreshRequest{
+		RefreshToken: refreshToken,
 	}
-
-	body := utils.DecodeBody(res.Body, logger)
-	var authRes AuthResponse
-	if err := json.Unmarshal(body, &authRes); err != nil {
-		return nil, fmt.Errorf("failed to decode JSON response: %v", err)
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
 	}
 
-	return &authRes, nil
+	logger := self.logs.Enter("refresh_token")
+	url := self.baseUrl + "/auth/api/v1/external-tokens/token-refresh"
+	logger.SetRequest(utils.SecureStruct(reqBody), url)
+
+	return self.requestTokens(ctx, logger, metrics.OperationRefresh, url, jsonData)
 }
 
 func NewGatewayClient(
 	ctx context.Context,
 	settings connect.Settings,
-	client *http.Client,
-	conn *db.Queries,
-	prodBaseUrl, sandoxBaseUrl, callbackUrl string,
-) (GatewayClient, connect.InteractionLogs, error) {
+	il *connect.InteractionLogs,
+	config *ClientConfig,
+) (*GatewayClient, error) {
 	var baseUrl string
 	if settings.Sandbox {
-		baseUrl = sandoxBaseUrl
+		baseUrl = config.SandboxBaseUrl
 	} else {
-		baseUrl = prodBaseUrl
+		baseUrl = config.ProdBaseUrl
 	}
-
-	il := connect.EmptyInteractionLogs()
+	tokens := config.Tokens
 
 	// BAD, but I log settings anyway :3
 	sum := sha256.Sum256(fmt.Appendf(nil, "%s:%s", settings.Login, settings.Password))
 	credentialsHash := hex.EncodeToString(sum[:])
 
-	cached, err := conn.GetTokenCache(ctx, credentialsHash)
-	if err == nil {
-		// "Перед каждым запросом всегда проверяйте время жизни токена. Если до его истечения остается менее минуты, получите новый токен доступа к сервису."
-		if time.Since(cached.AccessRefreshedAt) < ACCESS_TOKEN_TTL-time.Minute {
-			log.Printf("Using cached access token: %s", cached.AccessToken)
-			return GatewayClient{
-				client:       client,
-				accessToken:  cached.AccessToken,
-				refreshToken: cached.RefreshToken,
-				baseUrl:      baseUrl,
-				callbackUrl:  callbackUrl,
-			}, il, nil
+	gatewayClient := &GatewayClient{
+		client:          config.Client,
+		baseUrl:         baseUrl,
+		tokens:          tokens,
+		breaker:         config.Breakers.Get(baseUrl),
+		limiters:        config.Limiters,
+		timeouts:        config.Timeouts,
+		retry:           config.Retry,
+		settings:        settings,
+		credentialsHash: credentialsHash,
+		logs:            il,
+		logger:          utils.Logger(ctx),
+	}
+
+	if cached, ok := tokens.get(ctx, credentialsHash); ok && tokens.accessValid(cached) {
+		gatewayClient.logger.Debug("Using cached access token", "credentials_hash", credentialsHash, "access_refreshed_at", cached.accessRefreshedAt)
+		metrics.TokenCache(metrics.TokenCacheHit)
+		gatewayClient.useTokens(cached)
+		return gatewayClient, nil
+	}
+
+	pair, err := tokens.acquire(ctx, credentialsHash, gatewayClient.acquisitionTimeout(), func(ctx context.Context, current *tokenPair) (tokenPair, error) {
+		// Other request could have renewed the tokens while this one waited for the database
+		if current != nil && tokens.accessValid(*current) {
+			metrics.TokenCache(metrics.TokenCacheHit)
+			return *current, nil
 		}
 
-		log.Printf("Refreshing expired access token with refresh token: %s", cached.RefreshToken)
-		refreshRes, err := refreshAccessToken(
-			client,
-			&il,
-			baseUrl,
-			cached.RefreshToken,
-		)
-		if err == nil {
-			_ = conn.UpsertTokenCache(ctx, db.UpsertTokenCacheParams{
-				CredentialsHash: credentialsHash,
-				AccessToken:     refreshRes.AccessToken,
-				RefreshToken:    cached.RefreshToken,
-			})
-			return GatewayClient{
-				client:       client,
-				accessToken:  refreshRes.AccessToken,
-				refreshToken: cached.RefreshToken,
-				baseUrl:      baseUrl,
-				callbackUrl:  callbackUrl,
-			}, il, nil
-		} else {
-			log.Printf("Failed to refresh access token: %v", err)
+		if current != nil && tokens.refreshValid(*current) {
+			pair, err := gatewayClient.refresh(ctx, *current)
+			if err == nil {
+				return pair, nil
+			}
+			gatewayClient.logger.Warn("Failed to refresh access token", "err", err)
+		} else if current != nil {
+			gatewayClient.logger.Info("Refresh token is expired, skipping refresh")
 		}
-	} else {
-		log.Printf("Failed fetch auth tokens from db: %v", err)
+
+		return gatewayClient.login(ctx)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to login client: %w", err)
 	}
 
-	log.Printf("Obtaining fresh pair of access and refresh tokens")
-	auth, err := obtainFreshTokens(
-		client,
-		&il,
-		baseUrl,
-		settings.Login,
-		settings.Password,
-	)
+	gatewayClient.useTokens(pair)
+	return gatewayClient, nil
+}
+
+// Upper bound for a token refresh followed by a login, both with their retries
+func (self *GatewayClient) acquisitionTimeout() time.Duration {
+	attempts := time.Duration(max(self.retry.MaxAttempts, 1))
+	return 2 * attempts * (self.timeouts.Login + self.retry.MaxBackoff)
+}
+
+func (self *GatewayClient) useTokens(pair tokenPair) {
+	self.tokenPair = pair
+}
+
+// Exchange the refresh token for a new access token.
+// Provider may rotate the refresh token, in that case the new one starts its own lifetime.
+func (self *GatewayClient) refresh(ctx context.Context, current tokenPair) (tokenPair, error) {
+	self.logger.Info("Refreshing expired access token")
+	metrics.TokenCache(metrics.TokenCacheRefresh)
+	refreshRes, err := withRetry(ctx, self.retry, self.logger, func(int) (*AuthResponse, error) {
+		return self.refreshAccessToken(ctx, current.refreshToken)
+	})
 	if err != nil {
-		return GatewayClient{}, il, fmt.Errorf("failed to login client: %w", err)
+		return tokenPair{}, err
 	}
 
-	conn.UpsertTokenCache(ctx, db.UpsertTokenCacheParams{
-		CredentialsHash: credentialsHash,
-		AccessToken:     auth.AccessToken,
-		RefreshToken:    auth.RefreshToken,
+	now := time.Now()
+	pair := tokenPair{
+		accessToken:        refreshRes.AccessToken,
+		refreshToken:       current.refreshToken,
+		accessRefreshedAt:  now,
+		refreshRefreshedAt: current.refreshRefreshedAt,
+	}
+	if refreshRes.RefreshToken != "" && refreshRes.RefreshToken != current.refreshToken {
+		pair.refreshToken = refreshRes.RefreshToken
+		pair.refreshRefreshedAt = now
+	}
+
+	return pair, nil
+}
+
+// Obtain fresh pair of tokens with merchant credentials
+func (self *GatewayClient) login(ctx context.Context) (tokenPair, error) {
+	self.logger.Info("Obtaining fresh pair of access and refresh tokens")
+	metrics.TokenCache(metrics.TokenCacheLogin)
+	auth, err := withRetry(ctx, self.retry, self.logger, func(int) (*AuthResponse, error) {
+		return self.obtainFreshTokens(ctx)
 	})
+	if err != nil {
+		return tokenPair{}, err
+	}
 
-	return GatewayClient{
-		client:       client,
-		refreshToken: auth.RefreshToken,
-		accessToken:  auth.AccessToken,
-		baseUrl:      baseUrl,
-		callbackUrl:  callbackUrl,
-	}, il, nil
+	now := time.Now()
+	return tokenPair{
+		accessToken:        auth.AccessToken,
+		refreshToken:       auth.RefreshToken,
+		accessRefreshedAt:  now,
+		refreshRefreshedAt: now,
+	}, nil
 }
 
-func (self *GatewayClient) makeRequest(method string, path string, body any, logger *connect.LogWriter) (*http.Response, error) {
+// Provider rejected the access token before it expired, get a new one
+func (self *GatewayClient) reauthenticate(ctx context.Context) error {
+	revoked := self.tokenPair
+
+	pair, err := self.tokens.acquire(ctx, self.credentialsHash, self.acquisitionTimeout(), func(ctx context.Context, current *tokenPair) (tokenPair, error) {
+		// Concurrent request already replaced the revoked token
+		if current != nil && current.accessToken != revoked.accessToken && self.tokens.accessValid(*current) {
+			return *current, nil
+		}
+
+		if current != nil {
+			revoked = *current
+		}
+
+		if self.tokens.refreshValid(revoked) {
+			pair, err := self.refresh(ctx, revoked)
+			if err == nil {
+				return pair, nil
+			}
+			self.logger.Warn("Failed to refresh revoked access token", "err", err)
+		}
+
+		return self.login(ctx)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to login client: %w", err)
+	}
+
+	self.useTokens(pair)
+	return nil
+}
+
+// Every retry is recorded in its own interaction log span
+func (self *GatewayClient) attemptSpan(logger *connect.LogWriter, attempt int) *connect.LogWriter {
+	if attempt == 0 || logger == nil {
+		return logger
+	}
+	return self.logs.Enter(logger.Kind())
+}
+
+// Send the request and read the response into the span of the last attempt
+func (self *GatewayClient) makeRequest(ctx context.Context, operation string, timeout time.Duration, method string, path string, body any, logger *connect.LogWriter) (int, []byte, error) {
 	url := self.baseUrl + path
-	log.Printf("DEBUG: Making %s request to %s", method, url)
+	self.logger.Debug("Making gateway request", "method", method, "url", url)
 
 	var (
-		bodyBytes []byte
-		err       error
+		bodyBytes   []byte
+		securedBody string
+		err         error
 	)
 
 	if body != nil {
-		securedBody := utils.SecureStruct(body)
-		log.Printf("DEBUG: Gateway request body: %s", securedBody)
-
-		if logger != nil {
-			logger.SetRequest(securedBody, url)
-		}
+		securedBody = utils.SecureStruct(body)
+		self.logger.Debug("Gateway request body", "body", securedBody)
 
 		bodyBytes, err = json.Marshal(body)
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+			return 0, nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
-	} else if logger != nil {
-		logger.SetRequest("", url)
 	}
 
-	req, err := http.NewRequest(method, url, bytes.NewReader(bodyBytes))
+	res, err := self.sendRequest(ctx, operation, timeout, method, url, bodyBytes, securedBody, logger)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if res.StatusCode != http.StatusUnauthorized && res.StatusCode != http.StatusForbidden {
+		resBody, err := readBody(res, operation, logger)
+		return res.StatusCode, resBody, err
+	}
+
+	// Access token was revoked early, keep the rejected response in its own span and replay the request once
+	self.logger.Warn("Gateway rejected access token, re-authenticating", "status", res.StatusCode)
+	readBody(res, operation, logger)
+
+	if err := self.reauthenticate(ctx); err != nil {
+		return 0, nil, err
+	}
+
+	var replayLogger *connect.LogWriter
+	if logger != nil {
+		replayLogger = self.logs.Enter(logger.Kind())
+	}
+	res, err = self.sendRequest(ctx, operation, timeout, method, url, bodyBytes, securedBody, replayLogger)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resBody, err := readBody(res, operation, replayLogger)
+	return res.StatusCode, resBody, err
+}
+
+func (self *GatewayClient) sendRequest(ctx context.Context, operation string, timeout time.Duration, method string, url string, bodyBytes []byte, securedBody string, logger *connect.LogWriter) (*http.Response, error) {
+	if logger != nil {
+		logger.SetRequest(securedBody, url)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(bodyBytes))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, transportError(operation, err)
 	}
 
 	req.Header.Set("content-type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+self.accessToken)
+	req.Header.Set("Authorization", "Bearer "+self.tokenPair.accessToken)
 
-	res, err := self.client.Do(req)
+	res, err := self.do(req, operation, timeout)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Gateway response status: %s", res.Status)
+	self.logger.Info("Gateway response", "method", method, "url", url, "status", res.StatusCode)
 	if logger != nil {
 		logger.SetStatus(res.StatusCode)
 	}
 	return res, nil
 }
 
-func (self *GatewayClient) Payment(req connect.PayoutRequest, logger *connect.LogWriter) (*http.Response, error) {
+func (self *GatewayClient) Payment(ctx context.Context, req connect.PayoutRequest, logger *connect.LogWriter) (PaymentResponse, error) {
 	if req.Payment.GatewayAmount == nil || req.Payment.GatewayCurrency == nil {
-		return nil, fmt.Errorf("Gateway connect request missing required fields")
+		return PaymentResponse{}, validationError(metrics.OperationPayment, "Gateway connect request missing required fields")
 	}
 
 	paymentRequest := PaymentRequest{
@@ -262,12 +440,24 @@ func (self *GatewayClient) Payment(req connect.PayoutRequest, logger *connect.Lo
 		ClientID:       "",
 	}
 
-	return self.makeRequest(http.MethodPost, "/pay/external-api/v1/payments", paymentRequest, logger)
+	status, body, err := self.makeRequest(ctx, metrics.OperationPayment, self.timeouts.Create, http.MethodPost, "/pay/external-api/v1/payments", paymentRequest, logger)
+	if err != nil {
+		return PaymentResponse{}, err
+	}
+
+	payment, err := decodeResponse[PaymentResponse](metrics.OperationPayment, status, http.StatusCreated, body)
+	if err != nil {
+		return PaymentResponse{}, err
+	}
+	if payment.ID == nil {
+		return PaymentResponse{}, missingFieldsError(metrics.OperationPayment, status)
+	}
+	return payment, nil
 }
 
-func (self *GatewayClient) Payout(req connect.PayoutRequest, logger *connect.LogWriter) (*http.Response, error) {
+func (self *GatewayClient) Payout(ctx context.Context, req connect.PayoutRequest, logger *connect.LogWriter) (PayoutResponse, error) {
 	if req.Payment.GatewayAmount == nil || req.Params.BankAccount.AccountNumber == nil {
-		return nil, fmt.Errorf("Gateway connect request missing required fields")
+		return PayoutResponse{}, validationError(metrics.OperationPayout, "Gateway connect request missing required fields")
 	}
 
 	payoutRequest := PayoutRequest{
@@ -284,19 +474,61 @@ func (self *GatewayClient) Payout(req connect.PayoutRequest, logger *connect.Log
 		ExternalID: req.Payment.Token,
 	}
 
-	return self.makeRequest(http.MethodPost, "/pay/external-api/v1/payouts", payoutRequest, logger)
+	status, body, err := self.makeRequest(ctx, metrics.OperationPayout, self.timeouts.Create, http.MethodPost, "/pay/external-api/v1/payouts", payoutRequest, logger)
+	if err != nil {
+		return PayoutResponse{}, err
+	}
+
+	payout, err := decodeResponse[PayoutResponse](metrics.OperationPayout, status, http.StatusCreated, body)
+	if err != nil {
+		return PayoutResponse{}, err
+	}
+	if payout.ID == nil {
+		return PayoutResponse{}, missingFieldsError(metrics.OperationPayout, status)
+	}
+	return payout, nil
 }
 
-func (self *GatewayClient) RequestPaymentStatus(req connect.StatusRequest, logger *connect.LogWriter) (*http.Response, error) {
+func (self *GatewayClient) RequestPaymentStatus(ctx context.Context, req connect.StatusRequest, logger *connect.LogWriter) (PaymentStatusResponse, error) {
 	if req.Payment.GatewayToken == nil {
-		return nil, fmt.Errorf("Gateway connect request is missing required fields")
+		return PaymentStatusResponse{}, validationError(metrics.OperationPaymentStatus, "Gateway connect request is missing required fields")
 	}
-	return self.makeRequest(http.MethodGet, "/pay/external-api/v1/payments/"+*req.Payment.GatewayToken, nil, logger)
+
+	return withRetry(ctx, self.retry, self.logger, func(attempt int) (PaymentStatusResponse, error) {
+		status, body, err := self.makeRequest(ctx, metrics.OperationPaymentStatus, self.timeouts.Status, http.MethodGet, "/pay/external-api/v1/payments/"+*req.Payment.GatewayToken, nil, self.attemptSpan(logger, attempt))
+		if err != nil {
+			return PaymentStatusResponse{}, err
+		}
+
+		providerStatus, err := decodeResponse[PaymentStatusResponse](metrics.OperationPaymentStatus, status, http.StatusOK, body)
+		if err != nil {
+			return PaymentStatusResponse{}, err
+		}
+		if providerStatus.ID == nil || providerStatus.Amount == nil {
+			return PaymentStatusResponse{}, missingFieldsError(metrics.OperationPaymentStatus, status)
+		}
+		return providerStatus, nil
+	})
 }
 
-func (self *GatewayClient) RequestPayoutStatus(req connect.StatusRequest, logger *connect.LogWriter) (*http.Response, error) {
+func (self *GatewayClient) RequestPayoutStatus(ctx context.Context, req connect.StatusRequest, logger *connect.LogWriter) (PayoutStatusResponse, error) {
 	if req.Payment.GatewayToken == nil {
-		return nil, fmt.Errorf("Gateway connect request is missing required fields")
+		return PayoutStatusResponse{}, validationError(metrics.OperationPayoutStatus, "Gateway connect request is missing required fields")
 	}
-	return self.makeRequest(http.MethodGet, "/pay/external-api/v1/payouts/"+*req.Payment.GatewayToken, nil, logger)
+
+	return withRetry(ctx, self.retry, self.logger, func(attempt int) (PayoutStatusResponse, error) {
+		status, body, err := self.makeRequest(ctx, metrics.OperationPayoutStatus, self.timeouts.Status, http.MethodGet, "/pay/external-api/v1/payouts/"+*req.Payment.GatewayToken, nil, self.attemptSpan(logger, attempt))
+		if err != nil {
+			return PayoutStatusResponse{}, err
+		}
+
+		providerStatus, err := decodeResponse[PayoutStatusResponse](metrics.OperationPayoutStatus, status, http.StatusOK, body)
+		if err != nil {
+			return PayoutStatusResponse{}, err
+		}
+		if providerStatus.ID == nil || providerStatus.Amount == nil {
+			return PayoutStatusResponse{}, missingFieldsError(metrics.OperationPayoutStatus, status)
+		}
+		return providerStatus, nil
+	})
 }
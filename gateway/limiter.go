@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dog4ik/stbl/metrics"
+	"golang.org/x/time/rate"
+)
+
+// Default rate limiting settings, requests are not limited unless a rate is configured
+const (
+	RATE_LIMIT_BURST    = 5
+	RATE_LIMIT_MAX_WAIT = 2 * time.Second
+)
+
+// Token bucket of a single operation type, zero rate disables the limit
+type RateLimit struct {
+	// Requests per second
+	Rate  float64
+	Burst int
+}
+
+// Provider request budgets of merchant credentials by operation type
+type RateLimits struct {
+	// Token obtain and refresh
+	Login RateLimit
+	// Payment and payout creation
+	Create RateLimit
+	// Payment and payout status
+	Status RateLimit
+	// How long a request may wait for the budget before it is rejected
+	MaxWait time.Duration
+}
+
+// Operation class that has its own request budget
+type limitClass int
+
+const (
+	limitClassLogin limitClass = iota
+	limitClassCreate
+	limitClassStatus
+)
+
+func classOf(operation string) limitClass {
+	switch operation {
+	case metrics.OperationLogin, metrics.OperationRefresh:
+		return limitClassLogin
+	case metrics.OperationPayment, metrics.OperationPayout:
+		return limitClassCreate
+	default:
+		return limitClassStatus
+	}
+}
+
+func (limits RateLimits) forClass(class limitClass) RateLimit {
+	switch class {
+	case limitClassLogin:
+		return limits.Login
+	case limitClassCreate:
+		return limits.Create
+	default:
+		return limits.Status
+	}
+}
+
+type limiterKey struct {
+	credentialsHash string
+	class           limitClass
+}
+
+// Rate limiters by credentials hash, shared between all gateway clients
+type Limiters struct {
+	limits RateLimits
+
+	mu       sync.Mutex
+	limiters map[limiterKey]*rate.Limiter
+}
+
+func NewLimiters(limits RateLimits) *Limiters {
+	return &Limiters{limits: limits, limiters: make(map[limiterKey]*rate.Limiter)}
+}
+
+func (limiters *Limiters) get(credentialsHash string, class limitClass) *rate.Limiter {
+	key := limiterKey{credentialsHash: credentialsHash, class: class}
+
+	limiters.mu.Lock()
+	defer limiters.mu.Unlock()
+
+	limiter, ok := limiters.limiters[key]
+	if !ok {
+		limit := limiters.limits.forClass(class)
+		limiter = rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))
+		limiters.limiters[key] = limiter
+	}
+	return limiter
+}
+
+// Wait for the credentials budget of the operation, reject the request if the wait would be too long
+func (limiters *Limiters) wait(ctx context.Context, credentialsHash string, operation string) error {
+	class := classOf(operation)
+	if limiters.limits.forClass(class).Rate <= 0 {
+		return nil
+	}
+
+	reservation := limiters.get(credentialsHash, class).Reserve()
+	delay := reservation.Delay()
+	if delay > limiters.limits.MaxWait {
+		reservation.Cancel()
+		metrics.RateLimited(operation)
+		return &Error{
+			Kind:      ErrorKindRateLimited,
+			Operation: operation,
+			Err:       fmt.Errorf("request budget of the merchant is exhausted, next slot in %s", delay.Round(time.Millisecond)),
+		}
+	}
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return transportError(operation, ctx.Err())
+	}
+}
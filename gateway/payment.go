@@ -1,6 +1,6 @@
 package gateway
 
-import "log"
+import "log/slog"
 
 type PaymentRequest struct {
 	Amount         float64               `json:"amount,omitempty"`
@@ -82,7 +82,7 @@ func (status StblPaymentStatus) ToRPStatus() string {
 	case PayStatusCanceled, PayStatusAppealRejected:
 		return "declined"
 	default:
-		log.Printf("WARN: unhandled payment status: %s", status)
+		slog.Warn("Unhandled payment status", "status", status)
 		return "pending"
 	}
 }
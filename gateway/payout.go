@@ -1,6 +1,6 @@
 package gateway
 
-import "log"
+import "log/slog"
 
 type PayoutRequest struct {
 	Amount         float64 `json:"amount"`
@@ -72,7 +72,7 @@ func (status StblPayoutStatus) ToRPStatus() string {
 	case PayoutStatusPaid:
 		return "approved"
 	default:
-		log.Printf("WARN: unhandled payout status: %s", status)
+		slog.Warn("Unhandled payout status", "status", status)
 		return "pending"
 	}
 }
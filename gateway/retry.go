@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Default retry policy of idempotent provider operations
+const (
+	RETRY_MAX_ATTEMPTS = 3
+	RETRY_BACKOFF      = 200 * time.Millisecond
+	RETRY_MAX_BACKOFF  = 2 * time.Second
+)
+
+var RETRYABLE_STATUSES = []int{429, 500, 502, 503, 504}
+
+// Retries of idempotent provider operations: token obtain and refresh, payment and payout status.
+// Payment and payout creation are never retried.
+type RetryPolicy struct {
+	// Attempts including the first one, 1 disables retries
+	MaxAttempts int
+	// Delay before the first retry, doubled on every next one
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Provider response statuses worth another attempt
+	RetryableStatuses []int
+}
+
+// Delay before the given retry with jitter, so concurrent clients do not retry in lockstep
+func (policy RetryPolicy) delay(retry int) time.Duration {
+	delay := policy.Backoff
+	for i := 1; i < retry && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, policy.MaxBackoff)
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// Failures without a provider answer or with a transient status
+func (policy RetryPolicy) retryable(err error) bool {
+	gatewayErr := AsError(err)
+	if gatewayErr == nil {
+		return false
+	}
+
+	switch gatewayErr.Kind {
+	case ErrorKindTimeout, ErrorKindNetwork:
+		return true
+	}
+	return slices.Contains(policy.RetryableStatuses, gatewayErr.Status)
+}
+
+// Run the attempt until it succeeds, fails with a permanent error or runs out of attempts
+func withRetry[T any](ctx context.Context, policy RetryPolicy, logger *slog.Logger, attempt func(attempt int) (T, error)) (T, error) {
+	var (
+		result T
+		err    error
+	)
+
+	for i := range max(policy.MaxAttempts, 1) {
+		if i > 0 {
+			delay := policy.delay(i)
+			logger.Warn("Retrying provider request", "attempt", i+1, "delay", delay, "err", err)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return result, err
+			case <-timer.C:
+			}
+		}
+
+		result, err = attempt(i)
+		if err == nil || !policy.retryable(err) {
+			return result, err
+		}
+	}
+
+	return result, err
+}
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Default per operation deadlines of provider requests
+const (
+	LOGIN_TIMEOUT  = 10 * time.Second
+	CREATE_TIMEOUT = 30 * time.Second
+	STATUS_TIMEOUT = 10 * time.Second
+)
+
+// Deadlines of provider requests by operation type
+type Timeouts struct {
+	// Token obtain and refresh
+	Login time.Duration
+	// Payment and payout creation
+	Create time.Duration
+	// Payment and payout status
+	Status time.Duration
+}
+
+// Response body that releases the request deadline once it is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body cancelBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+	return err
+}
+
+// Send the request with the deadline, the deadline covers reading the response body
+func doWithTimeout(client *http.Client, req *http.Request, operation string, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, transportError(operation, err)
+	}
+
+	res.Body = cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
@@ -0,0 +1,182 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/utils"
+)
+
+type tokenPair struct {
+	accessToken        string
+	refreshToken       string
+	accessRefreshedAt  time.Time
+	refreshRefreshedAt time.Time
+}
+
+type tokenCall struct {
+	done chan struct{}
+	pair tokenPair
+	err  error
+}
+
+// Provider tokens shared between all gateway clients.
+// In-memory cache in front of the token_cache table, only one login or refresh
+// per credentials is in flight at a time.
+type TokenStore struct {
+	conn       *db.Store
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	// Other instances rotate tokens in the table, so the in-memory copy is never trusted
+	shared bool
+
+	mu       sync.Mutex
+	cached   map[string]tokenPair
+	inflight map[string]*tokenCall
+}
+
+func NewTokenStore(conn *db.Store, accessTTL time.Duration, refreshTTL time.Duration) *TokenStore {
+	return &TokenStore{
+		conn:       conn,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		shared:     conn.Dialect() == db.DialectPostgres,
+		cached:     make(map[string]tokenPair),
+		inflight:   make(map[string]*tokenCall),
+	}
+}
+
+// "Перед каждым запросом всегда проверяйте время жизни токена. Если до его истечения остается менее минуты, получите новый токен доступа к сервису."
+func (store *TokenStore) accessValid(pair tokenPair) bool {
+	return time.Since(pair.accessRefreshedAt) < store.accessTTL-time.Minute
+}
+
+// Refresh attempt with an expired refresh token is bound to fail
+func (store *TokenStore) refreshValid(pair tokenPair) bool {
+	return time.Since(pair.refreshRefreshedAt) < store.refreshTTL-time.Minute
+}
+
+// Cached tokens of the credentials
+func (store *TokenStore) get(ctx context.Context, credentialsHash string) (tokenPair, bool) {
+	return store.load(ctx, store.conn, credentialsHash)
+}
+
+func (store *TokenStore) load(ctx context.Context, queries *db.Store, credentialsHash string) (tokenPair, bool) {
+	if !store.shared {
+		store.mu.Lock()
+		pair, ok := store.cached[credentialsHash]
+		store.mu.Unlock()
+		if ok {
+			return pair, true
+		}
+	}
+
+	cached, err := queries.GetTokenCache(ctx, credentialsHash)
+	if err != nil {
+		utils.Logger(ctx).Info("Failed fetch auth tokens from db", "err", err)
+		return tokenPair{}, false
+	}
+
+	pair := tokenPair{
+		accessToken:        cached.AccessToken,
+		refreshToken:       cached.RefreshToken,
+		accessRefreshedAt:  cached.AccessRefreshedAt,
+		refreshRefreshedAt: cached.RefreshRefreshedAt,
+	}
+
+	store.mu.Lock()
+	store.cached[credentialsHash] = pair
+	store.mu.Unlock()
+	return pair, true
+}
+
+func (store *TokenStore) put(ctx context.Context, queries *db.Store, credentialsHash string, pair tokenPair) {
+	store.mu.Lock()
+	store.cached[credentialsHash] = pair
+	store.mu.Unlock()
+
+	if err := queries.UpsertTokenCache(ctx, db.UpsertTokenCacheParams{
+		CredentialsHash:    credentialsHash,
+		AccessToken:        pair.accessToken,
+		RefreshToken:       pair.refreshToken,
+		AccessRefreshedAt:  pair.accessRefreshedAt.UTC(),
+		RefreshRefreshedAt: pair.refreshRefreshedAt.UTC(),
+	}); err != nil {
+		utils.Logger(ctx).Error("Failed to save auth tokens", "err", err)
+	}
+}
+
+// Run fn while no other instance acquires tokens of the credentials. On PostgreSQL the
+// tokens are read and saved in a transaction holding an advisory lock, so a refresh token
+// is never spent twice. A single instance is covered by the in-flight acquisition.
+func (store *TokenStore) exclusive(ctx context.Context, credentialsHash string, fn func(queries *db.Store) error) error {
+	if !store.shared {
+		return fn(store.conn)
+	}
+	return store.conn.InTx(ctx, func(queries *db.Store) error {
+		if err := queries.LockKey(ctx, "tokens:"+credentialsHash); err != nil {
+			return err
+		}
+		return fn(queries)
+	})
+}
+
+// Run token acquisition for the credentials unless one is already in flight,
+// in that case wait for it and reuse its result.
+// The acquisition receives currently cached tokens, if there are any. It is detached from
+// the caller's cancellation and bounded by the timeout instead, so a caller that goes away
+// does not fail everyone waiting for the same tokens.
+func (store *TokenStore) acquire(
+	ctx context.Context,
+	credentialsHash string,
+	timeout time.Duration,
+	acquisition func(ctx context.Context, current *tokenPair) (tokenPair, error),
+) (tokenPair, error) {
+	store.mu.Lock()
+	if call, ok := store.inflight[credentialsHash]; ok {
+		store.mu.Unlock()
+		utils.Logger(ctx).Debug("Waiting for in flight token acquisition")
+		select {
+		case <-call.done:
+			return call.pair, call.err
+		case <-ctx.Done():
+			return tokenPair{}, ctx.Err()
+		}
+	}
+
+	call := &tokenCall{done: make(chan struct{})}
+	store.inflight[credentialsHash] = call
+	store.mu.Unlock()
+
+	defer func() {
+		store.mu.Lock()
+		delete(store.inflight, credentialsHash)
+		store.mu.Unlock()
+		close(call.done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	call.err = store.exclusive(ctx, credentialsHash, func(queries *db.Store) error {
+		// Read after the lock, another instance may have just rotated the tokens
+		var current *tokenPair
+		if pair, ok := store.load(ctx, queries, credentialsHash); ok {
+			current = &pair
+		}
+
+		pair, err := acquisition(ctx, current)
+		if err != nil {
+			return err
+		}
+		call.pair = pair
+		if current == nil || *current != pair {
+			store.put(ctx, queries, credentialsHash, pair)
+		}
+		return nil
+	})
+
+	return call.pair, call.err
+}
@@ -0,0 +1,89 @@
+package gateway
+
+type TransitionResult int
+
+const (
+	// Status moves forward
+	TransitionAccepted TransitionResult = iota
+	// Status did not change
+	TransitionDuplicate
+	// Status moves backwards or is unknown
+	TransitionRejected
+)
+
+func (result TransitionResult) String() string {
+	switch result {
+	case TransitionAccepted:
+		return "accepted"
+	case TransitionDuplicate:
+		return "duplicate"
+	default:
+		return "rejected"
+	}
+}
+
+// Allowed provider status transitions of one operation type
+type StatusMachine struct {
+	transitions map[string][]string
+}
+
+// Check if the operation can move from one provider status to another.
+// Empty from status means the operation status is not known yet.
+func (machine StatusMachine) Check(from string, to string) TransitionResult {
+	if _, known := machine.transitions[to]; !known {
+		return TransitionRejected
+	}
+
+	if from == to {
+		return TransitionDuplicate
+	}
+
+	if from == "" {
+		return TransitionAccepted
+	}
+
+	for _, next := range machine.transitions[from] {
+		if next == to {
+			return TransitionAccepted
+		}
+	}
+	return TransitionRejected
+}
+
+var PaymentStatusMachine = StatusMachine{
+	transitions: map[string][]string{
+		string(PayStatusNew): {
+			string(PayStatusCompleted),
+			string(PayStatusCanceled),
+			string(PayStatusAppealConsideration),
+		},
+		string(PayStatusCompleted): {
+			string(PayStatusAppealConsideration),
+		},
+		string(PayStatusCanceled): {
+			string(PayStatusAppealConsideration),
+		},
+		string(PayStatusAppealConsideration): {
+			string(PayStatusAppealApproved),
+			string(PayStatusAppealRejected),
+		},
+		string(PayStatusAppealApproved): {},
+		string(PayStatusAppealRejected): {},
+	},
+}
+
+var PayoutStatusMachine = StatusMachine{
+	transitions: map[string][]string{
+		string(PayoutStatusAwaitingProcessing): {
+			string(PayoutStatusAwaitingConfirmation),
+			string(PayoutStatusPaid),
+			string(PayoutStatusDenied),
+		},
+		string(PayoutStatusAwaitingConfirmation): {
+			string(PayoutStatusPaid),
+			string(PayoutStatusDenied),
+		},
+		string(PayoutStatusPaid):   {},
+		string(PayoutStatusDenied): {},
+	},
+}
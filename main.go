@@ -2,67 +2,163 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	_ "embed"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"strconv"
-
-	_ "modernc.org/sqlite"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/dog4ik/stbl/api"
+	"github.com/dog4ik/stbl/config"
 	"github.com/dog4ik/stbl/db"
+	"github.com/dog4ik/stbl/metrics"
 	"github.com/dog4ik/stbl/utils"
-	"github.com/joho/godotenv"
 )
 
-const PORT uint16 = 3030
-
-//go:embed schema.sql
-var ddl string
-
 func main() {
-	err := godotenv.Load()
+	cfg, err := config.Load()
 	if err != nil {
-		log.Printf("WARN: Error loading .env file\n")
+		utils.Fatal("Invalid configuration", "err", err)
 	}
+	if err := utils.SetupLogger(cfg.Log.Level, cfg.Log.Format); err != nil {
+		utils.Fatal("Failed to setup logger", "err", err)
+	}
+	slog.Info("Loaded configuration", "config", cfg)
 
-	ctx := context.Background()
-
-	database_path := utils.ExpectEnv("DATABASE_PATH")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	env_port := utils.ExpectEnv("PORT")
-	port, err := strconv.Atoi(env_port)
+	conn, err := db.Open(string(cfg.Database.Url), db.ConnConfig{
+		ReadConns:   cfg.Database.ReadConns,
+		BusyTimeout: cfg.Database.BusyTimeout,
+	})
 	if err != nil {
-		log.Fatalf("Failed to convert env port to number")
+		utils.Fatal("Failed to connect to the database", "err", err)
 	}
+	slog.Info("Opened database", "dialect", conn.Dialect)
 
-	conn, err := sql.Open("sqlite", fmt.Sprintf("%s?cache=shared", database_path))
-	conn.SetMaxOpenConns(1)
-	if err != nil {
-		log.Fatalf("Failed to connect to the database: %s", err)
+	var command string
+	if len(os.Args) > 1 {
+		command = os.Args[1]
 	}
-	defer conn.Close()
-	log.Printf("%s", ddl)
-	if _, err := conn.ExecContext(ctx, ddl); err != nil {
-		log.Fatalf("Failet to run init migration: %s", err)
+
+	if command == "migrate" || cfg.Database.AutoMigrate {
+		applied, err := db.Migrate(ctx, conn.DB, conn.Dialect)
+		for _, migration := range applied {
+			slog.Info("Applied migration", "version", migration.Version, "name", migration.Name)
+		}
+		if err != nil {
+			utils.Fatal("Failed to migrate the database", "err", err)
+		}
+	} else if err := db.CheckSchema(ctx, conn.DB, conn.Dialect); err != nil {
+		utils.Fatal("Database schema does not match, run the migrate command", "err", err)
+	}
+	if command == "migrate" {
+		return
+	}
+
+	keyring := cfg.Encryption.Keyring
+	if !keyring.Enabled() {
+		slog.Warn("ENCRYPTION_KEYS is not set, merchant secrets are stored in plaintext")
 	}
+	queries := db.NewStore(conn, keyring)
 
-	queries := db.New(conn)
+	if command != "" {
+		runCommand(ctx, command, queries)
+		return
+	}
 
 	mux := http.NewServeMux()
 
-	state := api.NewState(queries)
+	state := api.NewState(conn.DB, queries, cfg)
+	shutdownTimeout := cfg.ShutdownTimeout
+
+	// Workers outlive the signal until in-flight requests are drained
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Go(func() { state.RunCallbackWorker(workersCtx) })
+	workers.Go(func() { state.RunStatusPoller(workersCtx) })
+	workers.Go(func() { state.RunInteractionLogPurge(workersCtx) })
 
 	mux.HandleFunc("POST /payout", state.PayoutHandler)
 	mux.HandleFunc("POST /pay", state.PaymentHandler)
 	mux.HandleFunc("POST /status", state.StatusHandler)
 	mux.HandleFunc("POST /callback/pay", state.PaymentCallbackHandler)
 	mux.HandleFunc("POST /callback/payout", state.PayoutCallbackHandler)
+	mux.HandleFunc("POST /callback/pay/{secret}", state.PaymentCallbackHandler)
+	mux.HandleFunc("POST /callback/payout/{secret}", state.PayoutCallbackHandler)
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.HandleFunc("GET /healthz", state.HealthHandler)
+	mux.HandleFunc("GET /readyz", state.ReadinessHandler)
+	mux.HandleFunc("GET /admin/circuits", state.RequireAdmin(state.CircuitsHandler))
+	mux.HandleFunc("GET /admin/logs", state.RequireAdmin(state.InteractionLogsHandler))
+	mux.HandleFunc("GET /admin/mappings", state.RequireAdmin(state.AdminMappingsHandler))
+	mux.HandleFunc("GET /admin/transactions", state.RequireAdmin(state.AdminTransactionsHandler))
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("0.0.0.0:%d", cfg.Port),
+		Handler: api.WithRequestLogging(mux),
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Started listening", "port", cfg.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		utils.Fatal("Failed to listen and serve", "err", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to drain in-flight requests", "err", err)
+	}
+
+	stopWorkers()
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-workersDone:
+		// Callbacks enqueued by the last requests are delivered before exit
+		state.DrainCallbacks(shutdownCtx)
+	case <-shutdownCtx.Done():
+		slog.Error("Background workers did not stop before the shutdown deadline")
+	}
 
-	log.Printf("Started Listening on port %d", port)
+	if err := conn.Close(); err != nil {
+		slog.Error("Failed to close the database", "err", err)
+	}
+	slog.Info("Shutdown complete")
+}
 
-	err = http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", port), mux)
-	log.Fatalf("Failed to listen and serve: %s", err)
+// One-off maintenance commands, the server is not started
+func runCommand(ctx context.Context, command string, queries *db.Store) {
+	switch command {
+	case "rotate-keys":
+		// Encrypts plaintext rows as well, so it doubles as the migration of existing data
+		stats, err := queries.RotateKeys(ctx)
+		if err != nil {
+			utils.Fatal("Failed to rotate encryption keys", "err", err)
+		}
+		slog.Info("Rotated encryption keys",
+			"mappings", stats.Mappings,
+			"token_cache", stats.TokenCache,
+			"gateway_settings", stats.GatewaySettings,
+		)
+	default:
+		utils.Fatal("Unknown command", "command", command)
+	}
 }
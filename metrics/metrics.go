@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Provider operations
+const (
+	OperationPayment       = "payment"
+	OperationPayout        = "payout"
+	OperationPaymentStatus = "payment_status"
+	OperationPayoutStatus  = "payout_status"
+	OperationLogin         = "login"
+	OperationRefresh       = "refresh"
+)
+
+// Token cache outcomes
+const (
+	TokenCacheHit     = "hit"
+	TokenCacheRefresh = "refresh"
+	TokenCacheLogin   = "login"
+)
+
+// Business callback delivery outcomes
+const (
+	CallbackDelivered = "delivered"
+	CallbackRetry     = "retry"
+	CallbackDead      = "dead"
+)
+
+// Status label of provider requests that did not get a response
+const statusError = "error"
+
+var (
+	gatewayRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stbl_gateway_requests_total",
+		Help: "Requests sent to the provider by operation, response status and sandbox flag.",
+	}, []string{"operation", "status", "sandbox"})
+
+	gatewayRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "stbl_gateway_request_duration_seconds",
+		Help:    "Provider request latency by operation, response status and sandbox flag.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "status", "sandbox"})
+
+	providerCallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stbl_provider_callbacks_total",
+		Help: "Verified provider callbacks by operation and mapped RP status.",
+	}, []string{"operation", "rp_status"})
+
+	businessCallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stbl_business_callbacks_total",
+		Help: "Business callback delivery attempts by result.",
+	}, []string{"result"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stbl_gateway_rate_limited_total",
+		Help: "Provider requests rejected because the merchant's request budget was exhausted.",
+	}, []string{"operation"})
+
+	tokenCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stbl_token_cache_total",
+		Help: "Provider token lookups by outcome: cache hit, refresh or fresh login.",
+	}, []string{"result"})
+)
+
+// Record provider request, res is nil when the request failed without a response
+func ObserveGatewayRequest(operation string, sandbox bool, started time.Time, res *http.Response) {
+	status := statusError
+	if res != nil {
+		status = strconv.Itoa(res.StatusCode)
+	}
+	sandboxLabel := strconv.FormatBool(sandbox)
+
+	gatewayRequests.WithLabelValues(operation, status, sandboxLabel).Inc()
+	gatewayRequestDuration.WithLabelValues(operation, status, sandboxLabel).Observe(time.Since(started).Seconds())
+}
+
+func ProviderCallback(operation string, rpStatus string) {
+	providerCallbacks.WithLabelValues(operation, rpStatus).Inc()
+}
+
+func BusinessCallback(result string) {
+	businessCallbacks.WithLabelValues(result).Inc()
+}
+
+func RateLimited(operation string) {
+	rateLimited.WithLabelValues(operation).Inc()
+}
+
+func TokenCache(result string) {
+	tokenCache.WithLabelValues(result).Inc()
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -13,12 +13,12 @@ INSERT INTO token_cache (
     access_refreshed_at,
     refresh_refreshed_at
 )
-VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+VALUES (?, ?, ?, ?, ?)
 ON CONFLICT(credentials_hash) DO UPDATE SET
     access_token        = excluded.access_token,
     refresh_token       = excluded.refresh_token,
-    access_refreshed_at = CURRENT_TIMESTAMP,
-    refresh_refreshed_at = CURRENT_TIMESTAMP;
+    access_refreshed_at = excluded.access_refreshed_at,
+    refresh_refreshed_at = excluded.refresh_refreshed_at;
 
 -- name: GetTokenCache :one
 SELECT
@@ -28,3 +28,188 @@ SELECT
     refresh_refreshed_at
 FROM token_cache
 WHERE credentials_hash = ?;
+
+-- name: EnqueueCallback :one
+INSERT INTO callback_outbox (gateway_id, token, payload, next_attempt_at, request_id)
+VALUES (?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: ListDueCallbacks :many
+SELECT * FROM callback_outbox
+WHERE status = 'pending' AND next_attempt_at <= ?
+ORDER BY next_attempt_at
+LIMIT ?;
+
+-- name: ClaimCallback :execrows
+UPDATE callback_outbox
+SET next_attempt_at = sqlc.arg(lease_until)
+WHERE id = sqlc.arg(id) AND status = 'pending' AND next_attempt_at <= sqlc.arg(now);
+
+-- name: MarkCallbackDelivered :exec
+UPDATE callback_outbox
+SET status = 'delivered',
+    attempts = attempts + 1,
+    last_error = NULL,
+    updated_at = ?
+WHERE id = ?;
+
+-- name: MarkCallbackFailed :exec
+UPDATE callback_outbox
+SET status = ?,
+    attempts = attempts + 1,
+    last_error = ?,
+    next_attempt_at = ?,
+    updated_at = ?
+WHERE id = ?;
+
+-- name: CreateGatewaySettings :exec
+INSERT INTO gateway_settings (gateway_id, login, password, sandbox) VALUES (?, ?, ?, ?);
+
+-- name: GetGatewaySettings :one
+SELECT * FROM gateway_settings
+WHERE gateway_id = ? LIMIT 1;
+
+-- name: CreateCallbackRejection :exec
+INSERT INTO callback_rejections (path, remote_addr, reason, body, body_size, body_sha256) VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: CreateTransaction :one
+INSERT INTO transactions (
+    token,
+    operation_type,
+    amount,
+    currency,
+    rp_status,
+    sandbox,
+    external_id,
+    created_at,
+    updated_at
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, sqlc.arg(now), sqlc.arg(now))
+RETURNING *;
+
+-- name: SetTransactionGateway :exec
+UPDATE transactions
+SET gateway_id = ?,
+    provider_amount = ?,
+    provider_status = ?,
+    rp_status = ?,
+    redirect_url = ?,
+    updated_at = ?
+WHERE token = ?;
+
+-- name: SetTransactionRPStatus :exec
+UPDATE transactions
+SET rp_status = ?,
+    updated_at = ?
+WHERE token = ?;
+
+-- name: UpdateTransactionStatus :exec
+UPDATE transactions
+SET provider_status = ?,
+    provider_amount = ?,
+    rp_status = ?,
+    updated_at = ?
+WHERE gateway_id = ?;
+
+-- name: GetTransactionByToken :one
+SELECT * FROM transactions
+WHERE token = ? LIMIT 1;
+
+-- name: GetTransactionByGatewayID :one
+SELECT * FROM transactions
+WHERE gateway_id = ? LIMIT 1;
+
+-- name: CreateStatusTransition :exec
+INSERT INTO status_transitions (gateway_id, from_status, to_status, source, accepted, created_at)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: ListPendingTransactions :many
+SELECT * FROM transactions
+WHERE rp_status = 'pending'
+    AND gateway_id IS NOT NULL
+    AND created_at >= sqlc.arg(created_after)
+    AND COALESCE(polled_at, created_at) <= sqlc.arg(stale_before)
+ORDER BY COALESCE(polled_at, created_at)
+LIMIT sqlc.arg(limit);
+
+-- name: ClaimTransactionPoll :execrows
+UPDATE transactions
+SET polled_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id) AND COALESCE(polled_at, created_at) <= sqlc.arg(stale_before);
+
+-- name: CreateInteractionLog :exec
+INSERT INTO interaction_logs (
+    token,
+    gateway_id,
+    request_id,
+    kind,
+    request_url,
+    request_params,
+    status,
+    response,
+    duration,
+    created_at
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: ListInteractionLogs :many
+SELECT * FROM interaction_logs
+WHERE token = sqlc.arg(token) OR gateway_id = sqlc.arg(gateway_id)
+ORDER BY created_at, id;
+
+-- name: PurgeInteractionLogs :execrows
+DELETE FROM interaction_logs
+WHERE created_at < ?;
+
+-- name: PurgeCallbackRejections :execrows
+DELETE FROM callback_rejections
+WHERE created_at < ?;
+
+-- name: SearchMappings :many
+SELECT id, gateway_id, token FROM gateway_id_mapping
+WHERE token = sqlc.arg(token) OR gateway_id = sqlc.arg(gateway_id)
+ORDER BY id;
+
+-- name: ListCallbacksByGatewayID :many
+SELECT * FROM callback_outbox
+WHERE gateway_id = ?
+ORDER BY created_at, id;
+
+-- name: ListTransactions :many
+SELECT * FROM transactions
+WHERE rp_status = COALESCE(sqlc.narg(rp_status), rp_status)
+    AND operation_type = COALESCE(sqlc.narg(operation_type), operation_type)
+    AND created_at >= sqlc.arg(created_after)
+    AND created_at < sqlc.arg(created_before)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(limit);
+
+-- name: ListMappingSecrets :many
+SELECT id, merchant_private_key FROM gateway_id_mapping
+ORDER BY id;
+
+-- name: UpdateMappingSecret :execrows
+UPDATE gateway_id_mapping
+SET merchant_private_key = sqlc.arg(merchant_private_key)
+WHERE id = sqlc.arg(id) AND merchant_private_key = sqlc.arg(previous_merchant_private_key);
+
+-- name: ListTokenCacheSecrets :many
+SELECT id, access_token, refresh_token FROM token_cache
+ORDER BY id;
+
+-- name: UpdateTokenCacheSecrets :execrows
+UPDATE token_cache
+SET access_token = sqlc.arg(access_token),
+    refresh_token = sqlc.arg(refresh_token)
+WHERE id = sqlc.arg(id)
+    AND access_token = sqlc.arg(previous_access_token)
+    AND refresh_token = sqlc.arg(previous_refresh_token);
+
+-- name: ListGatewaySettingsSecrets :many
+SELECT id, password FROM gateway_settings
+ORDER BY id;
+
+-- name: UpdateGatewaySettingsSecret :execrows
+UPDATE gateway_settings
+SET password = sqlc.arg(password)
+WHERE id = sqlc.arg(id) AND password = sqlc.arg(previous_password);
@@ -2,7 +2,7 @@ package utils
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"strconv"
 	"strings"
 )
@@ -25,6 +25,15 @@ func isPANKey(key string) bool {
 	return false
 }
 
+// Credentials and provider tokens are hidden completely
+func isSecretKey(key string) bool {
+	switch strings.ToLower(key) {
+	case "password", "access_token", "refresh_token":
+		return true
+	}
+	return false
+}
+
 func isCVVKey(key string) bool {
 	k := strings.ToLower(key)
 	return strings.Contains(k, "cvv") ||
@@ -38,7 +47,7 @@ func SecureJSON(data any) string {
 
 	result, err := json.Marshal(secured)
 	if err != nil {
-		log.Printf("ERROR: failed to secure json payload: %s", err)
+		slog.Error("Failed to secure json payload", "err", err)
 		return ""
 	}
 
@@ -55,7 +64,9 @@ func secureValue(v any) any {
 			isCvv := isCVVKey(k)
 
 			newVal := val
-			if isPan || isCvv {
+			if isSecretKey(k) {
+				newVal = "***"
+			} else if isPan || isCvv {
 				switch typed := val.(type) {
 				case string:
 					if isPan {
@@ -3,7 +3,7 @@ package utils
 import (
 	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/dog4ik/stbl/connect"
@@ -15,7 +15,7 @@ func DecodeJSONRequest[T any](r io.Reader, w http.ResponseWriter) (T, error) {
 
 	v, err := UnmarshalBytes[T](body)
 	if err != nil {
-		log.Printf("ERROR: Error unmarshalling JSON: %s", err)
+		slog.Error("Error unmarshalling JSON", "err", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return v, err
 	}
@@ -31,7 +31,7 @@ func DecodeBody(r io.Reader, logger *connect.LogWriter) []byte {
 
 	var temp any
 	if err := json.Unmarshal(bytes, &temp); err != nil {
-		log.Printf("ERROR: Body decoder failed to unmarshal JSON: %s", err)
+		slog.Error("Body decoder failed to unmarshal JSON", "err", err)
 		if logger != nil {
 			logger.SetResponse(string(bytes))
 		}
@@ -39,7 +39,7 @@ func DecodeBody(r io.Reader, logger *connect.LogWriter) []byte {
 	}
 
 	secured := SecureJSON(temp)
-	log.Printf("DEBUG: JSON payload: %s", secured)
+	slog.Debug("JSON payload", "body", secured)
 	if logger != nil {
 		logger.SetResponse(secured)
 	}
@@ -49,7 +49,7 @@ func DecodeBody(r io.Reader, logger *connect.LogWriter) []byte {
 func UnmarshalBytes[T any](bytes []byte) (T, error) {
 	var result T
 	if err := json.Unmarshal(bytes, &result); err != nil {
-		log.Printf("ERROR: Error converting JSON to target type: %s", err)
+		slog.Error("Error converting JSON to target type", "err", err)
 		return result, err
 	}
 	return result, nil
@@ -63,18 +63,18 @@ func DecodeJSONRespnose[T any](r *http.Response, logger *connect.LogWriter) (T,
 	}
 	var temp any
 	if err := json.Unmarshal(bytes, &temp); err != nil {
-		log.Printf("ERROR: Error unmarshalling JSON: %s", err)
+		slog.Error("Error unmarshalling JSON", "err", err)
 		return result, err
 	}
 
 	secured := SecureJSON(temp)
-	log.Printf("DEBUG: JSON response: %s", secured)
+	slog.Debug("JSON response", "body", secured)
 	if logger != nil {
 		logger.SetResponse(secured)
 	}
 
 	if err := json.Unmarshal(bytes, &result); err != nil {
-		log.Printf("ERROR: Error converting JSON to target type: %s", err)
+		slog.Error("Error converting JSON to target type", "err", err)
 		return result, err
 	}
 
@@ -85,7 +85,7 @@ func WriteJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("content-type", "application/json")
 
 	if err := json.NewEncoder(w).Encode(v); err != nil {
-		log.Printf("ERROR: JSON encode failed: %v", err)
+		slog.Error("JSON encode failed", "err", err)
 	}
 }
 